@@ -0,0 +1,184 @@
+package weaver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// cacheFile is the name of the file a Cache is saved to, in its directory.
+const cacheFile = "weaver-cache.json"
+
+// A Cache remembers the validators (ETag and Last-Modified headers) of the
+// responses received, so that later checks of the same links can send
+// conditional requests. A server that answers "304 Not Modified" doesn't
+// need to send the page again, and the link is reported as OK. The bodies of
+// HTML pages are cached too, so that the links on unchanged pages can still
+// be followed. A Cache is safe for concurrent use, and may be shared between
+// checkers.
+type Cache struct {
+	dir     string
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+	Body         []byte `json:"body,omitempty"`
+}
+
+// NewCache returns an empty cache, held only in memory.
+func NewCache() *Cache {
+	return &Cache{entries: map[string]cacheEntry{}}
+}
+
+// OpenCache returns a cache stored in the directory dir, loading any entries
+// saved there by Save. The directory is created if it doesn't exist.
+func OpenCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	ca := NewCache()
+	ca.dir = dir
+	data, err := os.ReadFile(filepath.Join(dir, cacheFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ca, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &ca.entries); err != nil {
+		return nil, fmt.Errorf("reading cache %s: %w", dir, err)
+	}
+	return ca, nil
+}
+
+// Save writes the cache's entries to its directory, replacing any saved
+// before, so that they can be loaded by OpenCache. It does nothing for a
+// cache created by NewCache.
+func (ca *Cache) Save() error {
+	if ca.dir == "" {
+		return nil
+	}
+	ca.mu.Lock()
+	data, err := json.Marshal(ca.entries)
+	ca.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(ca.dir, cacheFile+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(ca.dir, cacheFile))
+}
+
+func (ca *Cache) get(link string) (cacheEntry, bool) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	e, ok := ca.entries[link]
+	return e, ok
+}
+
+func (ca *Cache) put(link string, e cacheEntry) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	ca.entries[link] = e
+}
+
+// addValidators makes req conditional on the cached response for its URL
+// having changed, if there is one. A GET request for an HTML page is only
+// made conditional if the page's body was cached, so that its links can
+// still be followed.
+func (ca *Cache) addValidators(req *http.Request) {
+	e, ok := ca.get(req.URL.String())
+	if !ok {
+		return
+	}
+	if req.Method == http.MethodGet && e.Body == nil && isHTML(e.ContentType) {
+		return
+	}
+	if e.ETag != "" {
+		req.Header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		req.Header.Set("If-Modified-Since", e.LastModified)
+	}
+}
+
+// update records the validators of resp, a response to a request for link,
+// or, if it's "304 Not Modified", replaces its body with the cached one.
+// Redirected responses aren't cached.
+func (ca *Cache) update(link string, resp *http.Response) {
+	if resp.Request == nil || resp.Request.URL.String() != link {
+		return
+	}
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		e, ok := ca.get(link)
+		if !ok {
+			return
+		}
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(e.Body))
+		if resp.Header.Get("Content-Type") == "" && e.ContentType != "" {
+			resp.Header.Set("Content-Type", e.ContentType)
+		}
+	case http.StatusOK:
+		e := cacheEntry{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			ContentType:  resp.Header.Get("Content-Type"),
+		}
+		if e.ETag == "" && e.LastModified == "" {
+			return
+		}
+		ca.put(link, e)
+		if resp.Request.Method == http.MethodGet && isHTML(e.ContentType) {
+			resp.Body = &cachingBody{ReadCloser: resp.Body, ca: ca, link: link, entry: e}
+		}
+	}
+}
+
+// isConditional reports whether req was made conditional by a Cache.
+func isConditional(req *http.Request) bool {
+	return req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+}
+
+// cachingBody saves a copy of the body it reads in the cache, once it's been
+// read to the end.
+type cachingBody struct {
+	io.ReadCloser
+	ca    *Cache
+	link  string
+	entry cacheEntry
+	buf   bytes.Buffer
+}
+
+func (cb *cachingBody) Read(p []byte) (int, error) {
+	n, err := cb.ReadCloser.Read(p)
+	cb.buf.Write(p[:n])
+	if err == io.EOF {
+		cb.entry.Body = bytes.Clone(cb.buf.Bytes())
+		cb.ca.put(cb.link, cb.entry)
+	}
+	return n, err
+}
@@ -0,0 +1,64 @@
+package weaver
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// decodeBody replaces the body of resp with a reader that decompresses it
+// according to its Content-Encoding (gzip, deflate, or br). Bodies with any
+// other encoding are left as they are.
+func decodeBody(resp *http.Response) {
+	var r io.Reader
+	var err error
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(resp.Body)
+	case "deflate":
+		r, err = newDeflateReader(resp.Body)
+	case "br":
+		r = brotli.NewReader(resp.Body)
+	default:
+		return
+	}
+	if err != nil {
+		r = errReader{err}
+	}
+	resp.Body = decodedBody{Reader: r, Closer: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+// newDeflateReader returns a reader for a "deflate" encoded body. This should
+// be zlib-wrapped, but some servers send raw deflate data instead, so we
+// check for a zlib header.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+type decodedBody struct {
+	io.Reader
+	io.Closer
+}
+
+type errReader struct {
+	err error
+}
+
+func (e errReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
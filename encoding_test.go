@@ -0,0 +1,53 @@
+package weaver_test
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/bitfield/weaver"
+	"golang.org/x/time/rate"
+)
+
+func TestCompressedPagesAreParsedForLinks(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var enc io.WriteCloser
+		switch r.URL.Path {
+		case "/":
+			io.WriteString(w, `<a href="/gzip">gzip</a><a href="/deflate">deflate</a><a href="/br">br</a>`)
+			return
+		case "/gzip":
+			enc = gzip.NewWriter(w)
+		case "/deflate":
+			enc = zlib.NewWriter(w)
+		case "/br":
+			enc = brotli.NewWriter(w)
+		default:
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", r.URL.Path[1:])
+		io.WriteString(enc, `<html><body><a href="/found`+r.URL.Path+`">Found</a></body></html>`)
+		enc.Close()
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	found := map[string]bool{}
+	for _, res := range c.Results() {
+		found[res.Link] = true
+	}
+	for _, enc := range []string{"gzip", "deflate", "br"} {
+		if !found[ts.URL+"/found/"+enc] {
+			t.Errorf("link in %s-encoded page not found", enc)
+		}
+	}
+}
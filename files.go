@@ -0,0 +1,246 @@
+package weaver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os/exec"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+)
+
+var (
+	markdownLink = regexp.MustCompile(`\]\(\s*<?([^)\s>]+)>?(?:\s+"[^"]*")?\s*\)`)
+	markdownRef  = regexp.MustCompile(`(?m)^\s*\[[^\]]+\]:\s*<?(\S+?)>?(?:\s+.*)?$`)
+	autoLink     = regexp.MustCompile(`<(https?://[^>\s]+)>`)
+	htmlLink     = regexp.MustCompile(`(?i)\b(?:href|src)\s*=\s*["']([^"']+)["']`)
+)
+
+// CheckFiles checks the links in each Markdown or HTML file in fsys matching
+// glob, without needing a running server. Relative links are resolved
+// against the linking file and checked for existence in fsys; missing files
+// are reported as errors. Absolute HTTP and HTTPS links are checked over the
+// network, without following any links on the pages they point to. Links in
+// HTML files are found using LinkSelectors, as when crawling.
+func (c *Checker) CheckFiles(ctx context.Context, fsys fs.FS, glob string) error {
+	files, err := fs.Glob(fsys, glob)
+	if err != nil {
+		return err
+	}
+	return c.checkFiles(ctx, fsys, files)
+}
+
+// CheckFileList is like CheckFiles, but checks only the named files in fsys,
+// such as those changed in a pull request (see ChangedFiles). Files other than
+// Markdown or HTML files are ignored, as are files that don't exist, so that
+// deleted files can be listed harmlessly. Like CheckFiles, it makes no
+// requests in DryRun mode.
+func (c *Checker) CheckFileList(ctx context.Context, fsys fs.FS, names []string) error {
+	var files []string
+	for _, name := range names {
+		if !isDocFile(name) {
+			continue
+		}
+		if _, err := fs.Stat(fsys, name); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		files = append(files, name)
+	}
+	return c.checkFiles(ctx, fsys, files)
+}
+
+// ChangedFiles returns the paths of the files changed in the git working tree
+// in dir since ref (for example, "origin/main"), relative to dir, as listed
+// by "git diff --name-only".
+func ChangedFiles(ctx context.Context, dir, ref string) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "git", "diff", "--name-only", "--relative", ref, "--")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("git diff: %s", bytes.TrimSpace(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("git diff: %w", err)
+	}
+	var files []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// checkFiles checks the links in each of files in fsys. In DryRun mode, it
+// just says how many links it would check in each file.
+func (c *Checker) checkFiles(ctx context.Context, fsys fs.FS, files []string) error {
+	ctx, end := c.begin(ctx)
+	defer end()
+	if err := c.compileLinkSelectors(); err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		links := fileLinks(file, data, c.linkSelector)
+		if c.DryRun {
+			fmt.Fprintf(c.Output, "[DRY RUN] would check %d links in %s\n", len(links), file)
+			continue
+		}
+		for _, link := range links {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.checkFileLink(ctx, fsys, file, link)
+		}
+	}
+	return nil
+}
+
+// CheckHTML checks each link in the HTML document read from r, without
+// following any links from the pages they point to. The document's name is
+// used as the referrer. Relative links are resolved against base; if base is
+// nil, they're skipped. In DryRun mode, it just says how many links it would
+// check.
+func (c *Checker) CheckHTML(ctx context.Context, r io.Reader, name string, base *url.URL) error {
+	ctx, end := c.begin(ctx)
+	defer end()
+	if err := c.compileLinkSelectors(); err != nil {
+		return err
+	}
+	doc, err := htmlquery.Parse(r)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", name, err)
+	}
+	links := findLinks(doc, c.linkSelector)
+	if c.DryRun {
+		fmt.Fprintf(c.Output, "[DRY RUN] would check %d links in %s\n", len(links), name)
+		return nil
+	}
+	for _, found := range links {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		link := cleanHref(found.href)
+		u, err := url.Parse(link)
+		if err != nil {
+			c.addDiscovery(link, discovery{kind: found.kind, depth: 1})
+			c.RecordResult(link, name, err, nil)
+			continue
+		}
+		if u.Scheme == "mailto" || (u.Scheme == "" && u.Host == "" && u.Path == "") {
+			continue
+		}
+		if !u.IsAbs() {
+			if base == nil {
+				c.report(Result{
+					Link:     link,
+					Status:   StatusSkipped,
+					Message:  "relative link with no base URL",
+					Referrer: name,
+					Kind:     found.kind,
+					Depth:    1,
+				})
+				continue
+			}
+			u = base.ResolveReference(u)
+		}
+		c.addDiscovery(u.String(), discovery{kind: found.kind, depth: 1})
+		c.checkLink(ctx, u.String(), name)
+	}
+	return nil
+}
+
+// checkFileLink checks a single link found in file.
+func (c *Checker) checkFileLink(ctx context.Context, fsys fs.FS, file, link string) {
+	u, err := url.Parse(link)
+	if err != nil {
+		c.RecordResult(link, file, err, nil)
+		return
+	}
+	_, handled := c.schemeHandler(u)
+	switch {
+	case u.Scheme == "http" || u.Scheme == "https", handled:
+		c.checkLink(ctx, link, file)
+		return
+	case u.Scheme != "" || u.Host != "" || u.Path == "":
+		// mailto: links, fragments, and so on
+		return
+	}
+	target := path.Clean(path.Join(path.Dir(file), u.Path))
+	if strings.HasPrefix(u.Path, "/") {
+		target = path.Clean(strings.TrimPrefix(u.Path, "/"))
+	}
+	c.addReferrer(target, file)
+	if !c.markVisited(target) {
+		return
+	}
+	res := Result{
+		Link:     target,
+		Status:   StatusOK,
+		Message:  "file exists",
+		Referrer: file,
+	}
+	if _, err := fs.Stat(fsys, target); err != nil {
+		res.Status = StatusError
+		res.Message = "file not found"
+	}
+	c.report(res)
+}
+
+// isDocFile reports whether name is a Markdown or HTML file, judging by its
+// extension.
+func isDocFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown", ".html", ".htm":
+		return true
+	}
+	return false
+}
+
+// fileLinks returns the links in the Markdown or HTML file named file, whose
+// contents are data. Links in HTML files are those selected by expr, the
+// compiled LinkSelectors.
+func fileLinks(file string, data []byte, expr *xpath.Expr) []string {
+	switch strings.ToLower(path.Ext(file)) {
+	case ".html", ".htm":
+		doc, err := htmlquery.Parse(bytes.NewReader(data))
+		if err != nil {
+			return nil
+		}
+		var links []string
+		for _, found := range findLinks(doc, expr) {
+			links = append(links, cleanHref(found.href))
+		}
+		return links
+	}
+	// links are returned in the order they appear in the file
+	found := map[int]string{}
+	for _, re := range []*regexp.Regexp{markdownLink, markdownRef, autoLink, htmlLink} {
+		for _, m := range re.FindAllSubmatchIndex(data, -1) {
+			found[m[2]] = string(data[m[2]:m[3]])
+		}
+	}
+	positions := make([]int, 0, len(found))
+	for pos := range found {
+		positions = append(positions, pos)
+	}
+	slices.Sort(positions)
+	links := make([]string, 0, len(positions))
+	for _, pos := range positions {
+		links = append(links, found[pos])
+	}
+	return links
+}
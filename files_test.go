@@ -0,0 +1,328 @@
+package weaver_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/bitfield/weaver"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/time/rate"
+)
+
+func TestCheckFilesChecksLocalAndRemoteLinks(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	fsys := fstest.MapFS{
+		"README.md": {Data: []byte(`# Docs
+
+See the [guide](docs/guide.md#install), the [missing page](docs/missing.md),
+and <` + ts.URL + `/>. Email [us](mailto:us@example.com) or jump to [the top](#docs).
+`)},
+		"docs/guide.md": {Data: []byte(`Back to the [README](../README.md).
+
+[gone]: ` + ts.URL + `/gone
+`)},
+		"docs/notes.txt": {Data: []byte(`[ignored](nowhere.md)`)},
+	}
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	err := c.CheckFiles(context.Background(), fsys, "*/*.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.CheckFiles(context.Background(), fsys, "*.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []weaver.Result{
+		{
+			Link:      "README.md",
+			Status:    weaver.StatusOK,
+			Message:   "file exists",
+			Referrer:  "docs/guide.md",
+			Referrers: []string{"docs/guide.md"},
+		},
+		{
+			Link:        ts.URL + "/gone",
+			Status:      weaver.StatusError,
+			Message:     "404 Not Found",
+			Referrer:    "docs/guide.md",
+			Referrers:   []string{"docs/guide.md"},
+			Method:      "GET",
+			StatusCode:  404,
+			ContentType: "text/plain",
+		},
+		{
+			Link:      "docs/guide.md",
+			Status:    weaver.StatusOK,
+			Message:   "file exists",
+			Referrer:  "README.md",
+			Referrers: []string{"README.md"},
+		},
+		{
+			Link:      "docs/missing.md",
+			Status:    weaver.StatusError,
+			Message:   "file not found",
+			Referrer:  "README.md",
+			Referrers: []string{"README.md"},
+		},
+		{
+			Link:       ts.URL + "/",
+			Status:     weaver.StatusOK,
+			Message:    "200 OK",
+			Referrer:   "README.md",
+			Referrers:  []string{"README.md"},
+			Method:     "GET",
+			StatusCode: 200,
+		},
+	}
+	got := c.Results()
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestCheckFilesMakesNoRequestsInDryRun(t *testing.T) {
+	t.Parallel()
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer ts.Close()
+	fsys := fstest.MapFS{
+		"README.md": {Data: []byte(`See the [guide](guide.md) and <` + ts.URL + `/>.`)},
+	}
+	output := new(bytes.Buffer)
+	c := weaver.NewChecker()
+	c.Output = output
+	c.DryRun = true
+	err := c.CheckFiles(context.Background(), fsys, "*.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests > 0 {
+		t.Errorf("want no requests, got %d", requests)
+	}
+	if len(c.Results()) > 0 {
+		t.Errorf("want no results, got %v", c.Results())
+	}
+	if !strings.Contains(output.String(), "would check 2 links in README.md") {
+		t.Errorf("want description of file check, got %q", output.String())
+	}
+}
+
+func TestCheckFilesUsesLinkSelectorsForHTMLFiles(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"index.html": {Data: []byte(`<a href="a.html">A</a><div data-href="b.html">B</div><img src="c.png">`)},
+		"a.html":     {Data: []byte(`A`)},
+	}
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.LinkSelectors = append(c.LinkSelectors, "//*/@data-href")
+	err := c.CheckFiles(context.Background(), fsys, "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]weaver.Status{
+		"a.html": weaver.StatusOK,
+		"b.html": weaver.StatusError,
+	}
+	got := map[string]weaver.Status{}
+	for _, res := range c.Results() {
+		got[res.Link] = res.Status
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestCheckHTMLChecksLinksInDocument(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	doc := `<html><body>
+<a href="` + ts.URL + `/ok">Absolute</a>
+<a href="/missing">Relative</a>
+<a href="#top">Fragment</a>
+</body></html>`
+	base, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	err = c.CheckHTML(context.Background(), strings.NewReader(doc), "export.html", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]weaver.Status{
+		ts.URL + "/ok":      weaver.StatusOK,
+		ts.URL + "/missing": weaver.StatusError,
+	}
+	got := map[string]weaver.Status{}
+	for _, res := range c.Results() {
+		got[res.Link] = res.Status
+		if res.Referrer != "export.html" {
+			t.Errorf("want referrer %q, got %q", "export.html", res.Referrer)
+		}
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestCheckHTMLSkipsRelativeLinksWithoutBaseURL(t *testing.T) {
+	t.Parallel()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	err := c.CheckHTML(context.Background(), strings.NewReader(`<a href="page.html">Page</a>`), "stdin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := c.Results()
+	if len(got) != 1 || got[0].Status != weaver.StatusSkipped {
+		t.Errorf("want relative link skipped, got %v", got)
+	}
+}
+
+func TestCheckHTMLMakesNoRequestsInDryRun(t *testing.T) {
+	t.Parallel()
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer ts.Close()
+	output := new(bytes.Buffer)
+	c := weaver.NewChecker()
+	c.Output = output
+	c.DryRun = true
+	doc := strings.NewReader(`<a href="` + ts.URL + `/a">A</a><a href="` + ts.URL + `/b">B</a>`)
+	err := c.CheckHTML(context.Background(), doc, "page.html", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests > 0 {
+		t.Errorf("want no requests, got %d", requests)
+	}
+	if len(c.Results()) > 0 {
+		t.Errorf("want no results, got %v", c.Results())
+	}
+	if !strings.Contains(output.String(), "would check 2 links in page.html") {
+		t.Errorf("want description of HTML check, got %q", output.String())
+	}
+}
+
+func TestCheckFileListChecksOnlyListedDocFiles(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"changed.md":   {Data: []byte(`[gone](gone.md)`)},
+		"unchanged.md": {Data: []byte(`[also gone](also-gone.md)`)},
+		"main.go":      {Data: []byte(`// [not a link](nowhere.md)`)},
+	}
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	err := c.CheckFileList(context.Background(), fsys, []string{"changed.md", "main.go", "deleted.md"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []weaver.Result{{
+		Link:      "gone.md",
+		Status:    weaver.StatusError,
+		Message:   "file not found",
+		Referrer:  "changed.md",
+		Referrers: []string{"changed.md"},
+	}}
+	got := c.Results()
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestCheckFileListMakesNoRequestsInDryRun(t *testing.T) {
+	t.Parallel()
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer ts.Close()
+	fsys := fstest.MapFS{
+		"changed.md": {Data: []byte(`<` + ts.URL + `/>`)},
+	}
+	output := new(bytes.Buffer)
+	c := weaver.NewChecker()
+	c.Output = output
+	c.DryRun = true
+	err := c.CheckFileList(context.Background(), fsys, []string{"changed.md"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests > 0 {
+		t.Errorf("want no requests, got %d", requests)
+	}
+	if len(c.Results()) > 0 {
+		t.Errorf("want no results, got %v", c.Results())
+	}
+	if !strings.Contains(output.String(), "would check 1 links in changed.md") {
+		t.Errorf("want description of file check, got %q", output.String())
+	}
+}
+
+func TestChangedFilesListsFilesChangedSinceRef(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+		}
+	}
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	write("a.md", "A")
+	write("b.md", "B")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	write("b.md", "B, changed")
+	write("c.md", "C")
+	git("add", ".")
+	got, err := weaver.ChangedFiles(context.Background(), dir, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"b.md", "c.md"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
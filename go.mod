@@ -1,20 +1,21 @@
 module github.com/bitfield/weaver
 
-go 1.19
+go 1.23
 
 require (
+	github.com/andybalholm/brotli v1.2.5
 	github.com/antchfx/htmlquery v1.3.1
+	github.com/antchfx/xpath v1.3.0
 	github.com/fatih/color v1.16.0
 	github.com/google/go-cmp v0.6.0
+	github.com/mattn/go-isatty v0.0.20
+	golang.org/x/net v0.33.0
 	golang.org/x/time v0.5.0
 )
 
 require (
-	github.com/antchfx/xpath v1.3.0 // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
-	github.com/mattn/go-isatty v0.0.20 // indirect
-	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
 )
@@ -0,0 +1,531 @@
+package weaver
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats lists the names of the report formats supported by WriteReport.
+var Formats = []string{"text", "json", "csv", "csv-summary", "junit", "sarif", "html", "markdown", "dot"}
+
+var reportWriters = map[string]func(*Checker, io.Writer) error{
+	"text":        (*Checker).WriteText,
+	"json":        (*Checker).WriteJSON,
+	"csv":         (*Checker).WriteCSV,
+	"csv-summary": (*Checker).WriteCSVSummary,
+	"junit":       (*Checker).WriteJUnit,
+	"sarif":       (*Checker).WriteSARIF,
+	"html":        (*Checker).WriteHTML,
+	"markdown":    (*Checker).WriteMarkdown,
+	"dot":         (*Checker).WriteGraph,
+}
+
+// ValidateFormat returns an error if format isn't one of the Formats.
+func ValidateFormat(format string) error {
+	if !slices.Contains(Formats, format) {
+		return fmt.Errorf("unknown format %q (want %s)", format, strings.Join(Formats, ", "))
+	}
+	return nil
+}
+
+// WriteReport writes a report of the results to w in the given format, which
+// must be one of the Formats.
+func (c *Checker) WriteReport(w io.Writer, format string) error {
+	if err := ValidateFormat(format); err != nil {
+		return err
+	}
+	return reportWriters[format](c, w)
+}
+
+// WriteText writes every result to w, one per line, followed by the summary,
+// without color.
+func (c *Checker) WriteText(w io.Writer) error {
+	for _, res := range c.Results() {
+		if _, err := fmt.Fprintln(w, res.format(false)); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "\n%s\n", c.Summary())
+	return err
+}
+
+// WriteJSON writes the summary and results to w as a JSON object.
+func (c *Checker) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Summary Summary  `json:"summary"`
+		Results []Result `json:"results"`
+	}{
+		Summary: c.Summary(),
+		Results: c.Results(),
+	})
+}
+
+// JSONLWriter returns a function, suitable for use as an OnResult callback,
+// which writes each result it's called with to w as a JSON object on a line of
+// its own. Each line is written with a single call to w's Write method, so
+// that the lines written before a crash can still be read. Write errors are
+// ignored, since the check carries on regardless.
+func JSONLWriter(w io.Writer) func(Result) {
+	return func(res Result) {
+		data, err := json.Marshal(res)
+		if err != nil {
+			return
+		}
+		w.Write(append(data, '\n'))
+	}
+}
+
+// ReadResults reads the results from a report written by WriteJSON, or from
+// JSON lines written by JSONLWriter, so that they can be checked again with
+// Recheck.
+func ReadResults(r io.Reader) ([]Result, error) {
+	var results []Result
+	dec := json.NewDecoder(r)
+	for {
+		var v struct {
+			Results []Result `json:"results"`
+			Result
+		}
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			return results, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case v.Results != nil:
+			results = append(results, v.Results...)
+		case v.Link != "":
+			results = append(results, v.Result)
+		}
+	}
+}
+
+// WriteCSV writes the results to w as CSV, with a header row.
+func (c *Checker) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"status", "link", "message", "referrer", "method", "error_kind", "redirects", "content_type", "kind", "depth", "from_cache"})
+	for _, res := range c.Results() {
+		cw.Write([]string{
+			string(res.Status),
+			res.Link,
+			res.Message,
+			res.Referrer,
+			res.Method,
+			string(res.ErrorKind),
+			strconv.Itoa(res.Redirects),
+			res.ContentType,
+			res.Kind,
+			strconv.Itoa(res.Depth),
+			strconv.FormatBool(res.FromCache),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteCSVSummary writes the summary to w as CSV: a header row, and a row of
+// totals. Elapsed is given as a number of nanoseconds, as in the JSON report.
+func (c *Checker) WriteCSVSummary(w io.Writer) error {
+	s := c.Summary()
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"total", "ok", "errors", "warnings", "skipped", "elapsed", "bytes_downloaded"})
+	cw.Write([]string{
+		strconv.Itoa(s.Total),
+		strconv.Itoa(s.OK),
+		strconv.Itoa(s.Errors),
+		strconv.Itoa(s.Warnings),
+		strconv.Itoa(s.Skipped),
+		strconv.FormatInt(int64(s.Elapsed), 10),
+		strconv.FormatInt(s.BytesDownloaded, 10),
+	})
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteMarkdown writes a compact Markdown report of just the broken links
+// and warnings to w, suitable for posting as a pull request comment: a
+// heading with the counts, followed by a table with errors first. If there
+// are none, it says so.
+func (c *Checker) WriteMarkdown(w io.Writer) error {
+	var problems []Result
+	for _, res := range c.SortedResults() {
+		if res.Status == StatusError || res.Status == StatusWarning {
+			problems = append(problems, res)
+		}
+	}
+	if len(problems) == 0 {
+		_, err := fmt.Fprintln(w, "✅ No broken links found")
+		return err
+	}
+	summary := c.Summary()
+	fmt.Fprintf(w, "### ❌ %d broken links, %d warnings\n\n", summary.Errors, summary.Warnings)
+	fmt.Fprintln(w, "| Status | Link | Referrer | Message |")
+	fmt.Fprintln(w, "| --- | --- | --- | --- |")
+	for _, res := range problems {
+		_, err := fmt.Fprintf(w, "| %s | %s | %s | %s |\n",
+			string(res.Status),
+			markdownCell(res.Link),
+			markdownCell(res.Referrer),
+			markdownCell(res.Message),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markdownCell escapes s for use in a Markdown table cell.
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
+
+// WriteGraph writes the link graph to w in GraphViz DOT format, with an edge
+// from each page to every link found on it. Broken links are colored red,
+// and warnings orange. Render it with, for example, "dot -Tsvg".
+func (c *Checker) WriteGraph(w io.Writer) error {
+	results := c.Results()
+	fmt.Fprintln(w, "digraph links {")
+	for _, res := range results {
+		switch res.Status {
+		case StatusError:
+			fmt.Fprintf(w, "\t%s [color=red];\n", dotID(res.Link))
+		case StatusWarning:
+			fmt.Fprintf(w, "\t%s [color=orange];\n", dotID(res.Link))
+		}
+	}
+	for _, res := range results {
+		for _, ref := range res.Referrers {
+			if ref == "START" {
+				continue
+			}
+			fmt.Fprintf(w, "\t%s -> %s;\n", dotID(ref), dotID(res.Link))
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// dotID quotes s for use as a node ID in a DOT file.
+func dotID(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", " ").Replace(s) + `"`
+}
+
+// WriteJUnit writes the results to w as a JUnit XML test report, for CI
+// systems that display these. Each link is a test case: broken links are
+// failures, and skipped links are skipped. Warnings pass, but their messages
+// are included as the test case's output.
+func (c *Checker) WriteJUnit(w io.Writer) error {
+	summary := c.Summary()
+	suite := junitSuite{
+		Name:     "weaver",
+		Tests:    summary.Total,
+		Failures: summary.Errors,
+		Skipped:  summary.Skipped,
+		Time:     summary.Elapsed.Seconds(),
+	}
+	for _, res := range c.Results() {
+		tc := junitCase{
+			Name:      res.Link,
+			ClassName: res.Referrer,
+		}
+		switch res.Status {
+		case StatusError:
+			tc.Failure = &junitMessage{Message: res.Message}
+		case StatusSkipped:
+			tc.Skipped = &junitMessage{Message: res.Message}
+		case StatusWarning:
+			tc.SystemOut = "warning: " + res.Message
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     float64     `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteSARIF writes the broken links and warnings to w as a SARIF 2.1.0 log,
+// for code scanning tools such as GitHub's. Each result's location is the
+// page that links to it.
+func (c *Checker) WriteSARIF(w io.Writer) error {
+	results := []sarifResult{}
+	for _, res := range c.Results() {
+		var ruleID, level string
+		switch res.Status {
+		case StatusError:
+			ruleID, level = "broken-link", "error"
+		case StatusWarning:
+			ruleID, level = "link-warning", "warning"
+		default:
+			continue
+		}
+		sr := sarifResult{
+			RuleID:  ruleID,
+			Level:   level,
+			Message: sarifText{Text: fmt.Sprintf("%s (%s)", res.Link, res.Message)},
+		}
+		for _, ref := range res.Referrers {
+			if ref == "START" {
+				continue
+			}
+			sr.Locations = append(sr.Locations, sarifLocation{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: ref},
+				},
+			})
+		}
+		results = append(results, sr)
+	}
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "weaver",
+				InformationURI: "https://github.com/bitfield/weaver",
+				Rules: []sarifRule{
+					{ID: "broken-link", ShortDescription: sarifText{Text: "Broken link"}},
+					{ID: "link-warning", ShortDescription: sarifText{Text: "Link warning"}},
+				},
+			}},
+			Results: results,
+		}},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string    `json:"id"`
+	ShortDescription sarifText `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifText       `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifText struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// WriteHTML writes a self-contained HTML report of the results to w, with a
+// summary, a sortable and filterable table of every link checked, and a list
+// of the pages containing broken links.
+func (c *Checker) WriteHTML(w io.Writer) error {
+	results := c.Results()
+	stats := c.Stats()
+	report := htmlReport{
+		Stats:   stats,
+		Elapsed: stats.Elapsed.Round(100 * time.Millisecond),
+	}
+	broken := map[string][]htmlResult{}
+	for _, res := range results {
+		hr := htmlResult{
+			Link:     res.Link,
+			Status:   string(res.Status),
+			Message:  res.Message,
+			Referrer: res.Referrer,
+		}
+		report.Results = append(report.Results, hr)
+		if res.Status != StatusError && res.Status != StatusWarning {
+			continue
+		}
+		for _, ref := range res.Referrers {
+			if _, ok := broken[ref]; !ok {
+				report.Referrers = append(report.Referrers, ref)
+			}
+			broken[ref] = append(broken[ref], hr)
+		}
+	}
+	for _, ref := range report.Referrers {
+		report.Broken = append(report.Broken, brokenPage{
+			Page:  ref,
+			Links: broken[ref],
+		})
+	}
+	return htmlTemplate.Execute(w, report)
+}
+
+type htmlReport struct {
+	Stats     Stats
+	Elapsed   time.Duration
+	Results   []htmlResult
+	Referrers []string
+	Broken    []brokenPage
+}
+
+type htmlResult struct {
+	Link     string
+	Status   string
+	Message  string
+	Referrer string
+}
+
+type brokenPage struct {
+	Page  string
+	Links []htmlResult
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Weaver link report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; word-break: break-all; }
+th { cursor: pointer; background: #eee; }
+.OKAY, .SKIP { background: #e6f4e6; }
+.WARN { background: #fff4d6; }
+.DEAD { background: #fbe3e3; }
+#filter { margin: 1em 0; }
+</style>
+</head>
+<body>
+<h1>Weaver link report</h1>
+<p>Links: {{.Stats.Links}} ({{.Stats.OK}} OK, {{.Stats.Errors}} errors, {{.Stats.Warnings}} warnings) [{{.Elapsed}}]</p>
+{{- if .Broken}}
+<h2>Pages with broken links</h2>
+{{- range .Broken}}
+<h3>{{if eq .Page "START"}}START{{else}}<a href="{{.Page}}">{{.Page}}</a>{{end}}</h3>
+<ul>
+{{- range .Links}}
+<li class="{{.Status}}">[{{.Status}}] <a href="{{.Link}}">{{.Link}}</a> ({{.Message}})</li>
+{{- end}}
+</ul>
+{{- end}}
+{{- end}}
+<h2>All links</h2>
+<div id="filter">
+<input id="search" type="search" placeholder="Filter links">
+<select id="status">
+<option value="">All statuses</option>
+<option>OKAY</option>
+<option>WARN</option>
+<option>DEAD</option>
+<option>SKIP</option>
+</select>
+</div>
+<table id="results">
+<thead><tr><th>Status</th><th>Link</th><th>Message</th><th>Referrer</th></tr></thead>
+<tbody>
+{{- range .Results}}
+<tr class="{{.Status}}"><td>{{.Status}}</td><td><a href="{{.Link}}">{{.Link}}</a></td><td>{{.Message}}</td><td>{{if eq .Referrer "START"}}START{{else}}<a href="{{.Referrer}}">{{.Referrer}}</a>{{end}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+<script>
+(function () {
+  var table = document.getElementById("results");
+  var rows = Array.prototype.slice.call(table.tBodies[0].rows);
+  var search = document.getElementById("search");
+  var status = document.getElementById("status");
+  function filter() {
+    var text = search.value.toLowerCase();
+    rows.forEach(function (row) {
+      var matchText = row.textContent.toLowerCase().indexOf(text) !== -1;
+      var matchStatus = !status.value || row.className === status.value;
+      row.style.display = matchText && matchStatus ? "" : "none";
+    });
+  }
+  search.addEventListener("input", filter);
+  status.addEventListener("change", filter);
+  Array.prototype.forEach.call(table.tHead.rows[0].cells, function (th, col) {
+    var ascending = true;
+    th.addEventListener("click", function () {
+      rows.sort(function (a, b) {
+        var x = a.cells[col].textContent, y = b.cells[col].textContent;
+        return ascending ? x.localeCompare(y) : y.localeCompare(x);
+      });
+      ascending = !ascending;
+      rows.forEach(function (row) { table.tBodies[0].appendChild(row); });
+    });
+  });
+})();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,242 @@
+package weaver_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bitfield/weaver"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/time/rate"
+)
+
+func TestWriteHTMLIncludesSummaryAndBrokenLinks(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewTLSServer(
+		http.FileServerFS(testFS),
+	)
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.HTTPClient = ts.Client()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	buf := new(bytes.Buffer)
+	err := c.WriteHTML(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := buf.String()
+	for _, want := range []string{
+		"Links: 8 (4 OK, 3 errors, 1 warnings)",
+		`<h3><a href="` + ts.URL + `/go/sucks.html">`,
+		`<li class="DEAD">[DEAD] <a href="` + ts.URL + `/bogus">`,
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+	if strings.Contains(report, "\x1b[") {
+		t.Error("report contains terminal escape codes")
+	}
+}
+
+func TestWriteReportWritesEachFormat(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(testFS))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	tcs := map[string]string{
+		"text":        "[DEAD] " + ts.URL + "/bogus (404 Not Found)",
+		"json":        `"errors": 3`,
+		"csv":         "DEAD," + ts.URL + "/bogus,404 Not Found," + ts.URL + "/go/sucks.html",
+		"csv-summary": "total,ok,errors,warnings,skipped,elapsed,bytes_downloaded\n8,4,3,1,0,",
+		"junit":       `<testsuite name="weaver" tests="8" failures="3" skipped="0"`,
+		"sarif":       `"ruleId": "broken-link"`,
+		"html":        "Links: 8 (4 OK, 3 errors, 1 warnings)",
+		"dot":         `"` + ts.URL + `/go/sucks.html" -> "` + ts.URL + `/bogus";`,
+	}
+	for format, want := range tcs {
+		buf := new(bytes.Buffer)
+		err := c.WriteReport(buf, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("%s: report missing %q:\n%s", format, want, buf.String())
+		}
+	}
+}
+
+func TestWriteJSONIncludesSummaryAndResults(t *testing.T) {
+	t.Parallel()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.RecordResult("https://example.com/", "START", weaver.ErrSkip, nil)
+	buf := new(bytes.Buffer)
+	err := c.WriteJSON(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Summary weaver.Summary
+		Results []weaver.Result
+	}
+	err = json.Unmarshal(buf.Bytes(), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Summary.Total != 1 || got.Summary.Skipped != 1 {
+		t.Errorf("unexpected summary %+v", got.Summary)
+	}
+	if len(got.Results) != 1 || got.Results[0].Link != "https://example.com/" {
+		t.Errorf("unexpected results %+v", got.Results)
+	}
+}
+
+func TestJSONLWriterWritesEachResultAsALineOfJSON(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(testFS))
+	defer ts.Close()
+	buf := new(bytes.Buffer)
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.OnResult = weaver.JSONLWriter(buf)
+	c.Check(context.Background(), ts.URL)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	var got []weaver.Result
+	for _, line := range lines {
+		var res weaver.Result
+		if err := json.Unmarshal([]byte(line), &res); err != nil {
+			t.Fatalf("parsing line %q: %v", line, err)
+		}
+		got = append(got, res)
+	}
+	want := c.Results()
+	// Referrers found after a result is written aren't known when it's
+	// written
+	for i := range want {
+		want[i].Referrers = nil
+	}
+	for i := range got {
+		got[i].Referrers = nil
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestReadResultsReadsJSONReportsAndJSONLines(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(testFS))
+	defer ts.Close()
+	jsonl := new(bytes.Buffer)
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.OnResult = weaver.JSONLWriter(jsonl)
+	c.Check(context.Background(), ts.URL)
+	report := new(bytes.Buffer)
+	if err := c.WriteJSON(report); err != nil {
+		t.Fatal(err)
+	}
+	want := c.Results()
+	got, err := weaver.ReadResults(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	got, err = weaver.ReadResults(jsonl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Errorf("want %d results from JSON lines, got %d", len(want), len(got))
+	}
+}
+
+func TestWriteReportRejectsUnknownFormat(t *testing.T) {
+	t.Parallel()
+	c := weaver.NewChecker()
+	err := c.WriteReport(io.Discard, "yaml")
+	if err == nil {
+		t.Fatal("want error for unknown format, got nil")
+	}
+	if !strings.Contains(err.Error(), `unknown format "yaml"`) {
+		t.Errorf("unexpected error %q", err)
+	}
+}
+
+func TestWriteGraphWritesEdgesAndColorsBrokenLinks(t *testing.T) {
+	t.Parallel()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.RecordResult("https://example.com/", "START", nil, &http.Response{Status: "200 OK", StatusCode: http.StatusOK})
+	c.RecordResult("https://example.com/gone", "https://example.com/", nil, &http.Response{Status: "404 Not Found", StatusCode: http.StatusNotFound})
+	buf := new(bytes.Buffer)
+	err := c.WriteGraph(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph links {
+	"https://example.com/gone" [color=red];
+	"https://example.com/" -> "https://example.com/gone";
+}
+`
+	if !cmp.Equal(want, buf.String()) {
+		t.Error(cmp.Diff(want, buf.String()))
+	}
+}
+
+func TestWriteMarkdownListsOnlyProblems(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(testFS))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	buf := new(bytes.Buffer)
+	err := c.WriteMarkdown(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := buf.String()
+	for _, want := range []string{
+		"### ❌ 3 broken links, 1 warnings",
+		"| DEAD | " + ts.URL + "/bogus | " + ts.URL + "/go/sucks.html | 404 Not Found |",
+		"| WARN | http:// / |",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report missing %q:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "OKAY") {
+		t.Errorf("report includes OK links:\n%s", report)
+	}
+}
+
+func TestWriteMarkdownSaysSoWhenNoBrokenLinks(t *testing.T) {
+	t.Parallel()
+	c := weaver.NewChecker()
+	buf := new(bytes.Buffer)
+	err := c.WriteMarkdown(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "✅ No broken links found\n"
+	if buf.String() != want {
+		t.Errorf("want %q, got %q", want, buf.String())
+	}
+}
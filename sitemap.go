@@ -0,0 +1,244 @@
+package weaver
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// CheckSitemap checks each page listed in the sitemap at sitemapURL, without
+// following any links. Sitemap index files are followed to the sitemaps they
+// list, and gzipped sitemaps are decompressed automatically. In DryRun mode,
+// it just says which sitemap it would check.
+func (c *Checker) CheckSitemap(ctx context.Context, sitemapURL string) error {
+	if c.DryRun {
+		fmt.Fprintf(c.Output, "[DRY RUN] would check the pages listed in the sitemap at %s\n", sitemapURL)
+		return nil
+	}
+	ctx, end := c.begin(ctx)
+	defer end()
+	if err := c.logIn(ctx); err != nil {
+		return err
+	}
+	links, err := c.sitemapURLs(ctx, sitemapURL)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.checkLink(ctx, link, sitemapURL)
+	}
+	return nil
+}
+
+// seedFromSitemaps queues the pages listed in the sitemaps of the site at
+// base to be checked, after any links found by crawling. The sitemaps are
+// those listed in the site's robots.txt file or, if there are none, the one
+// at /sitemap.xml. A missing or invalid sitemap isn't an error, since the
+// site can still be crawled.
+func (c *Checker) seedFromSitemaps(ctx context.Context, base *url.URL) {
+	for _, sitemapURL := range c.discoverSitemaps(ctx, base) {
+		links, err := c.sitemapURLs(ctx, sitemapURL)
+		if err != nil {
+			c.log(slog.LevelDebug, "not using sitemap", "url", sitemapURL, "error", err)
+			continue
+		}
+		c.log(slog.LevelDebug, "queueing pages from sitemap", "url", sitemapURL, "pages", len(links))
+		items := make([]crawlItem, 0, len(links))
+		for _, link := range links {
+			items = append(items, crawlItem{URL: link, Referrer: sitemapURL})
+		}
+		c.push(items...)
+	}
+}
+
+// discoverSitemaps returns the URLs of the sitemaps listed in the robots.txt
+// file of the site at base, or if there are none, its /sitemap.xml.
+func (c *Checker) discoverSitemaps(ctx context.Context, base *url.URL) []string {
+	robots := base.ResolveReference(&url.URL{Path: "/robots.txt"})
+	fallback := []string{base.ResolveReference(&url.URL{Path: "/sitemap.xml"}).String()}
+	resp, _, err := c.fetch(ctx, http.MethodGet, robots.String())
+	if err != nil {
+		return fallback
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fallback
+	}
+	var sitemaps []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.EqualFold(strings.TrimSpace(key), "sitemap") {
+			sitemaps = append(sitemaps, strings.TrimSpace(value))
+		}
+	}
+	if len(sitemaps) == 0 {
+		return fallback
+	}
+	return sitemaps
+}
+
+// checkLink checks a single link, if not already visited, without parsing it
+// for further links. Once MaxTotalBytes has been reached, it does nothing.
+func (c *Checker) checkLink(ctx context.Context, link, referrer string) {
+	if c.overByteCap() {
+		return
+	}
+	page, err := url.Parse(link)
+	if err != nil {
+		c.RecordResult(link, referrer, err, nil)
+		return
+	}
+	c.addReferrer(page.String(), referrer)
+	if !c.markVisited(page.String()) {
+		return
+	}
+	if handler, ok := c.schemeHandler(page); ok {
+		c.report(c.handleScheme(ctx, handler, page, referrer))
+		return
+	}
+	resp, err := c.fetchLink(ctx, page.String())
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		c.RecordResult(page.String(), referrer, err, nil)
+		return
+	}
+	defer resp.Body.Close()
+	res := c.classify(page.String(), referrer, nil, resp)
+	c.checkCertExpiry(page, &res, resp)
+	c.report(res)
+}
+
+// Orphans compares the pages listed in the sitemap at sitemapURL with the
+// pages visited so far, usually by a previous call to Check. It returns the
+// orphans, which are listed in the sitemap but were never reached by
+// following links, and the unlisted pages, which were reached but aren't in
+// the sitemap. Only visited pages on the same host as the sitemap are
+// considered. Both lists are sorted.
+func (c *Checker) Orphans(ctx context.Context, sitemapURL string) (orphans, unlisted []string, err error) {
+	sm, err := url.Parse(sitemapURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	links, err := c.sitemapURLs(ctx, sitemapURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	listed := map[string]bool{}
+	for _, link := range links {
+		u, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
+		listed[normalizeURL(u)] = true
+	}
+	reached := map[string]bool{}
+	for _, link := range c.Visited() {
+		u, err := url.Parse(link)
+		if err != nil || !sameHost(u, sm) {
+			continue
+		}
+		reached[normalizeURL(u)] = true
+	}
+	for link := range listed {
+		if !reached[link] {
+			orphans = append(orphans, link)
+		}
+	}
+	for link := range reached {
+		if !listed[link] && link != normalizeURL(sm) {
+			unlisted = append(unlisted, link)
+		}
+	}
+	sort.Strings(orphans)
+	sort.Strings(unlisted)
+	return orphans, unlisted, nil
+}
+
+// sitemapURLs fetches the sitemap at sitemapURL and returns the page URLs it
+// lists, including those in any child sitemaps of a sitemap index.
+func (c *Checker) sitemapURLs(ctx context.Context, sitemapURL string) ([]string, error) {
+	var links []string
+	seen := map[string]bool{}
+	queue := []string{sitemapURL}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if seen[next] {
+			continue
+		}
+		seen[next] = true
+		sm, err := c.fetchSitemap(ctx, next)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range sm.URLs {
+			links = append(links, strings.TrimSpace(u.Loc))
+		}
+		for _, child := range sm.Sitemaps {
+			queue = append(queue, strings.TrimSpace(child.Loc))
+		}
+	}
+	return links, nil
+}
+
+func (c *Checker) fetchSitemap(ctx context.Context, sitemapURL string) (sitemap, error) {
+	resp, _, err := c.fetch(ctx, http.MethodGet, sitemapURL)
+	if err != nil {
+		return sitemap{}, fmt.Errorf("fetching sitemap: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return sitemap{}, fmt.Errorf("fetching sitemap %s: %s", sitemapURL, resp.Status)
+	}
+	sm, err := parseSitemap(resp.Body)
+	if err != nil {
+		return sitemap{}, fmt.Errorf("parsing sitemap %s: %w", sitemapURL, err)
+	}
+	return sm, nil
+}
+
+// parseSitemap reads a sitemap or sitemap index from r, decompressing it
+// first if it's gzipped.
+func parseSitemap(r io.Reader) (sitemap, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return sitemap{}, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+	var sm sitemap
+	if err := xml.NewDecoder(r).Decode(&sm); err != nil {
+		return sitemap{}, err
+	}
+	return sm, nil
+}
+
+// sitemap represents either a urlset or a sitemapindex document.
+type sitemap struct {
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
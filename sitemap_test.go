@@ -0,0 +1,203 @@
+package weaver_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bitfield/weaver"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/time/rate"
+)
+
+func TestCheckSitemapChecksEachListedPage(t *testing.T) {
+	t.Parallel()
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap_index.xml":
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>`+ts.URL+`/sitemap.xml.gz</loc></sitemap>
+</sitemapindex>`)
+		case "/sitemap.xml.gz":
+			gz := gzip.NewWriter(w)
+			io.WriteString(gz, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>`+ts.URL+`/a</loc></url>
+  <url>
+    <loc>
+      `+ts.URL+`/b
+    </loc>
+  </url>
+</urlset>`)
+			gz.Close()
+		case "/a":
+			io.WriteString(w, `<a href="/c">Not in sitemap</a>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	err := c.CheckSitemap(context.Background(), ts.URL+"/sitemap_index.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []weaver.Result{
+		{
+			Link:        ts.URL + "/a",
+			Status:      weaver.StatusOK,
+			Message:     "200 OK",
+			Referrer:    ts.URL + "/sitemap_index.xml",
+			Referrers:   []string{ts.URL + "/sitemap_index.xml"},
+			Method:      "GET",
+			StatusCode:  200,
+			ContentType: "text/html",
+		},
+		{
+			Link:        ts.URL + "/b",
+			Status:      weaver.StatusError,
+			Message:     "404 Not Found",
+			Referrer:    ts.URL + "/sitemap_index.xml",
+			Referrers:   []string{ts.URL + "/sitemap_index.xml"},
+			Method:      "GET",
+			StatusCode:  404,
+			ContentType: "text/plain",
+		},
+	}
+	got := c.Results()
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestCheckSitemapReturnsErrorForMissingSitemap(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	err := c.CheckSitemap(context.Background(), ts.URL+"/sitemap.xml")
+	if err == nil {
+		t.Error("want error for missing sitemap")
+	}
+}
+
+func TestCheckSitemapMakesNoRequestsInDryRun(t *testing.T) {
+	t.Parallel()
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer ts.Close()
+	output := new(bytes.Buffer)
+	c := weaver.NewChecker()
+	c.Output = output
+	c.DryRun = true
+	err := c.CheckSitemap(context.Background(), ts.URL+"/sitemap.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests > 0 {
+		t.Errorf("want no requests, got %d", requests)
+	}
+	if len(c.Results()) > 0 {
+		t.Errorf("want no results, got %v", c.Results())
+	}
+	if !strings.Contains(output.String(), "sitemap at "+ts.URL+"/sitemap.xml") {
+		t.Errorf("want description of sitemap check, got %q", output.String())
+	}
+}
+
+func TestOrphansComparesSitemapWithCrawl(t *testing.T) {
+	t.Parallel()
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>`+ts.URL+`/</loc></url>
+  <url><loc>`+ts.URL+`/linked</loc></url>
+  <url><loc>`+ts.URL+`/orphan</loc></url>
+</urlset>`)
+		case "/":
+			io.WriteString(w, `<a href="/linked">Linked</a><a href="/unlisted#top">Unlisted</a>`)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	orphans, unlisted, err := c.Orphans(context.Background(), ts.URL+"/sitemap.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOrphans := []string{ts.URL + "/orphan"}
+	if !cmp.Equal(wantOrphans, orphans) {
+		t.Error(cmp.Diff(wantOrphans, orphans))
+	}
+	wantUnlisted := []string{ts.URL + "/unlisted"}
+	if !cmp.Equal(wantUnlisted, unlisted) {
+		t.Error(cmp.Diff(wantUnlisted, unlisted))
+	}
+}
+
+func TestSeedFromSitemapsChecksPagesListedInRobotsSitemaps(t *testing.T) {
+	t.Parallel()
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			io.WriteString(w, "User-agent: *\nDisallow:\n\nSitemap: "+ts.URL+"/pages.xml\n")
+		case "/pages.xml":
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>`+ts.URL+`/linked</loc></url>
+  <url><loc>`+ts.URL+`/orphan</loc></url>
+</urlset>`)
+		case "/":
+			io.WriteString(w, `<a href="/linked">Linked</a>`)
+		case "/linked":
+		case "/orphan":
+			io.WriteString(w, `<a href="/gone">Gone</a>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.SeedFromSitemaps = true
+	err := c.Check(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		ts.URL:             "START",
+		ts.URL + "/linked": ts.URL,
+		ts.URL + "/orphan": ts.URL + "/pages.xml",
+		ts.URL + "/gone":   ts.URL + "/orphan",
+	}
+	results := c.Results()
+	if len(results) != len(want) {
+		t.Errorf("want each page checked once, got %v", results)
+	}
+	got := map[string]string{}
+	for _, res := range results {
+		got[res.Link] = res.Referrer
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
@@ -1,168 +1,2609 @@
 package weaver
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
+	"iter"
+	"log/slog"
+	"maps"
+	"mime"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
 	"os"
 	"os/signal"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
 	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"golang.org/x/net/html"
+	"golang.org/x/net/idna"
 	"golang.org/x/time/rate"
 )
 
 const (
-	maxRate       rate.Limit = 5
-	fakeUserAgent            = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/122.0.0.0 Safari/537.36"
+	maxRate               rate.Limit = 5
+	minRate               rate.Limit = 0.1
+	defaultGrowthFactor              = 1.5
+	defaultCooldownPeriod            = 10 * time.Second
+	shutdownGracePeriod              = time.Second
+	waitPollInterval                 = 500 * time.Millisecond
+	levelTrace                       = slog.LevelDebug - 4
+	acceptEncoding                   = "gzip, deflate, br"
+	fakeUserAgent                    = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/122.0.0.0 Safari/537.36"
 )
 
 type Checker struct {
-	Verbose    bool
-	Output     io.Writer
-	BaseURL    *url.URL
+	// Verbose prints log messages as well as results; it's equivalent to a
+	// Verbosity of 1.
+	Verbose bool
+	// ReportOK prints OK results too, without the log messages of verbose
+	// mode.
+	ReportOK bool
+	// Verbosity sets how much detail is printed; at level 2 and above, each
+	// result's depth is printed too.
+	Verbosity int
+	// Quiet suppresses printing results, unless verbose output is also enabled,
+	// in which case verbose wins.
+	Quiet bool
+	// ErrorsOnly suppresses printing warnings, so only errors are printed.
+	ErrorsOnly bool
+	// DryRun describes what would be checked, without making any requests.
+	DryRun bool
+	// CheckExternal checks links to other hosts. If it's false, they're
+	// recorded as skipped without any request.
+	CheckExternal bool
+	// ExternalOnly still crawls the site, but records its own pages as
+	// skipped, so that only links to other hosts are reported.
+	ExternalOnly bool
+	// Output receives the results and log messages.
+	Output io.Writer
+	// ErrorOutput, if set, receives errors and warnings, and everything else
+	// goes to Output.
+	ErrorOutput io.Writer
+	// Color determines whether results are printed in color.
+	Color ColorMode
+	// Strategy sets the order in which pages are crawled.
+	Strategy Strategy
+	// SeedFromSitemaps also checks the pages listed in the site's sitemaps,
+	// even if nothing links to them.
+	SeedFromSitemaps bool
+	// Progress, if set, receives a running count of the links checked, unless
+	// Quiet is set.
+	Progress io.Writer
+	// Logger, if set, receives log messages instead of Output.
+	Logger *slog.Logger
+	// BaseURL is the URL of the site being checked. Check sets it, and links
+	// to other hosts are external.
+	BaseURL *url.URL
+	// HTTPClient is used for requests. The transport-level options are
+	// applied to a copy of it, leaving it unchanged.
 	HTTPClient *http.Client
-	Limiter    *AdaptiveRateLimiter
-	results    []Result
-	visited    map[string]bool
+	// Proxy, if set, overrides any proxy configured by the environment.
+	Proxy *url.URL
+	// Accept, if set, is sent as the Accept header on every request.
+	Accept string
+	// AcceptLanguage, if set, is sent as the Accept-Language header on every
+	// request.
+	AcceptLanguage string
+	// SoftNotFoundPatterns lists patterns for the bodies of missing pages
+	// served with a success status; matching pages are recorded as warnings.
+	SoftNotFoundPatterns []*regexp.Regexp
+	// IgnoreResults lists patterns for links whose errors and warnings are
+	// recorded as skipped, and marked as ignored, instead, and aren't printed.
+	IgnoreResults []*regexp.Regexp
+	// BodyMatchers identify responses which are broken despite a success
+	// status.
+	BodyMatchers []BodyMatcher
+	// LinkSelectors are the XPath expressions selecting the attributes that
+	// contain links.
+	LinkSelectors []string
+	// CheckMixedContent warns about plain http URLs referenced by pages served
+	// over https.
+	CheckMixedContent bool
+	// IgnoreRobotsTag follows the links on pages whose X-Robots-Tag header
+	// says not to.
+	IgnoreRobotsTag bool
+	// CertExpiryWindow warns about TLS certificates that expire within this
+	// long. Zero disables the check.
+	CertExpiryWindow time.Duration
+	// OKStatusCodes lists response statuses to record as OK, whatever
+	// StatusClassifier says.
+	OKStatusCodes []int
+	// MaxBodySize limits how much of each page is read for links. Zero means
+	// no limit.
+	MaxBodySize int64
+	// BodyTimeout, if positive, limits the time taken to read each response
+	// body.
+	BodyTimeout time.Duration
+	// StatusClassifier determines the status of a result from the response
+	// status code.
+	StatusClassifier func(code int) Status
+	// OnResult, if set, is called with each result before it's added to the
+	// results. The call is made synchronously from the crawl, without holding
+	// any locks, so callbacks that do slow work, such as posting to a chat
+	// service, should hand it off to another goroutine.
+	OnResult func(Result)
+	// BeforeRequest, if set, is called with each request just before it's
+	// sent, and may modify it. If it returns an error, the request isn't sent.
+	BeforeRequest func(*http.Request) error
+	// SchemeHandlers check links with schemes other than http and https,
+	// keyed by scheme.
+	SchemeHandlers map[string]SchemeHandler
+	// MaxPathSegments skips links on the site with more path segments than
+	// this, as likely crawler traps. Zero disables the check.
+	MaxPathSegments int
+	// MaxRepeatedSegments skips links on the site in which any path segment
+	// appears more than this many times. Zero disables the check.
+	MaxRepeatedSegments int
+	// MaxQueryVariants limits how many links to the same page with different
+	// query strings are checked. Zero means no limit.
+	MaxQueryVariants int
+	// FailFast stops checking as soon as a broken link is found.
+	FailFast bool
+	// Strict records warnings as errors.
+	Strict bool
+	// FixedDelay, if positive, waits that long between requests, instead of
+	// adjusting the rate with Limiter.
+	FixedDelay time.Duration
+	// MaxResponseTime, if positive, warns about links to other hosts that
+	// take longer than this to respond.
+	MaxResponseTime time.Duration
+	// MaxRateLimitRetries is how many times a request answered with "429 Too
+	// Many Requests" is retried, each time with a reduced rate limit.
+	MaxRateLimitRetries int
+	// RetryStatusCodes lists the response statuses that are retried, up to
+	// MaxRetries times, waiting RetryBackoff before the first retry and
+	// doubling the wait for each one after that.
+	RetryStatusCodes []int
+	// MaxRetries is how many times a request is retried, for RetryStatusCodes
+	// and RetryOnReset.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry.
+	RetryBackoff time.Duration
+	// RetryOnReset retries requests whose connection was reset, or closed
+	// before the whole response arrived, in the same way as RetryStatusCodes.
+	RetryOnReset bool
+	// PerURLBudget, if positive, limits the total time spent on each link,
+	// including all retries and reading the response body.
+	PerURLBudget time.Duration
+	// Cache, if set, makes requests conditional on the cached response having
+	// changed.
+	Cache *Cache
+	// MaxTotalBytes, if positive, stops checking once this many bytes have
+	// been downloaded in total.
+	MaxTotalBytes int64
+	// WarnCrossDomainRedirect warns about links that redirect to a different
+	// host.
+	WarnCrossDomainRedirect bool
+	// WarnProtocolRelative records an OK result for a link first found as a
+	// protocol-relative link as a warning.
+	WarnProtocolRelative bool
+	// WarnPrivateTargets warns about links to localhost or private IP
+	// addresses from a public site, without checking them.
+	WarnPrivateTargets bool
+	// MaxRedirects is how many redirects are followed for each link.
+	MaxRedirects int
+	// ResolveOverrides maps host names to the IP addresses to connect to
+	// instead of looking them up.
+	ResolveOverrides map[string]string
+	// InsecureSkipVerify disables TLS certificate verification.
+	InsecureSkipVerify bool
+	// PathPrefix, if set, limits crawling to pages on the site whose paths
+	// begin with it. Other pages on the site are checked, but not crawled.
+	PathPrefix string
+	// SamePathPrefix limits crawling in the same way to pages under the
+	// directory of the start URL.
+	SamePathPrefix bool
+	// CircuitBreakerThreshold is how many requests in a row to a host must
+	// fail because it couldn't be reached before the rest are short-circuited.
+	// Zero disables the circuit breaker.
+	CircuitBreakerThreshold int
+	// CircuitBreakerCooldown is how long a host's requests are short-circuited
+	// before it's tried again.
+	CircuitBreakerCooldown time.Duration
+	// PerHostConcurrency limits how many requests to each host can be in
+	// progress at once, which guards against overloading a host when CheckOne
+	// is called from several goroutines; a single crawl only sends one request
+	// at a time anyway. Zero, the default, means no limit.
+	PerHostConcurrency int
+	// HeadFirst checks every link with a HEAD request first, sending a GET
+	// request only for pages on the site, whose links need checking too.
+	HeadFirst bool
+	// ExternalPolicy, if set, overrides the retry settings for links to other
+	// sites.
+	ExternalPolicy *ExternalPolicy
+	// Login, if set, is submitted before checking, so that the pages behind
+	// it can be checked.
+	Login *Login
+	// Limiter adjusts the request rate according to the server's responses.
+	Limiter        *AdaptiveRateLimiter
+	mu             sync.Mutex
+	results        []Result
+	visited        map[string]bool
+	referrers      map[string][]string
+	discovered     map[string]discovery
+	aliases        map[string]string
+	completed      map[string]bool
+	certChecked    map[string]bool
+	queryVariants  map[string]int
+	pending        []crawlItem
+	pathPrefix     string
+	linkSelector   *xpath.Expr
+	client         *http.Client
+	clientFrom     *http.Client
+	cancel         context.CancelFunc
+	hostFailures   map[string]int
+	circuits       map[string]time.Time
+	hostSlots      map[string]chan struct{}
+	failed         bool
+	loggedIn       bool
+	requests       int
+	bytes          int64
+	byteCapReached bool
+	latency        time.Duration
+	started        time.Time
+	finished       time.Time
+	lastRequest    time.Time
 }
 
 func NewChecker() *Checker {
 	return &Checker{
-		Verbose: false,
-		Output:  os.Stdout,
+		Verbose:       false,
+		Output:        os.Stdout,
+		Color:         ColorAuto,
+		CheckExternal: true,
 		HTTPClient: &http.Client{
-			Timeout: 5 * time.Second,
+			Transport: http.DefaultTransport.(*http.Transport).Clone(),
+			Timeout:   5 * time.Second,
 		},
-		Limiter: NewAdaptiveRateLimiter(),
-		visited: map[string]bool{},
+		CertExpiryWindow:        14 * 24 * time.Hour,
+		MaxBodySize:             10 << 20,
+		LinkSelectors:           slices.Clone(DefaultLinkSelectors),
+		MaxPathSegments:         20,
+		MaxRepeatedSegments:     3,
+		MaxQueryVariants:        20,
+		StatusClassifier:        DefaultStatusClassifier,
+		Limiter:                 NewAdaptiveRateLimiter(),
+		MaxRateLimitRetries:     5,
+		RetryStatusCodes:        []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		MaxRetries:              2,
+		MaxRedirects:            10,
+		RetryBackoff:            500 * time.Millisecond,
+		RetryOnReset:            true,
+		PerURLBudget:            30 * time.Second,
+		CircuitBreakerThreshold: 3,
+		CircuitBreakerCooldown:  time.Minute,
+		SchemeHandlers:          map[string]SchemeHandler{},
+		visited:                 map[string]bool{},
+		hostFailures:            map[string]int{},
+		circuits:                map[string]time.Time{},
+		hostSlots:               map[string]chan struct{}{},
+		referrers:               map[string][]string{},
+		discovered:              map[string]discovery{},
+		aliases:                 map[string]string{},
+		completed:               map[string]bool{},
+		certChecked:             map[string]bool{},
+		queryVariants:           map[string]int{},
 	}
 }
 
-func (c *Checker) Check(ctx context.Context, site string) {
-	base, err := url.Parse(site)
+// Check crawls site, checking every link found on its pages. If
+// SeedFromSitemaps is set, the pages listed in the site's sitemaps are checked
+// too, even if nothing links to them. It returns an error if the start page
+// itself couldn't be checked, meaning nothing else was.
+func (c *Checker) Check(ctx context.Context, site string) error {
+	ctx, end := c.begin(ctx)
+	defer end()
+	base, defaulted, err := parseStartURL(site)
+	if c.DryRun {
+		c.describe(site, base, err)
+		return nil
+	}
 	if err != nil {
 		c.RecordResult(site, "START", err, nil)
-		return
+		return fmt.Errorf("invalid start URL %q: %w", site, err)
+	}
+	if err := c.compileLinkSelectors(); err != nil {
+		return err
+	}
+	if err := c.logIn(ctx); err != nil {
+		return err
 	}
+	if defaulted {
+		base = c.fallBackToHTTP(ctx, base)
+	}
+	site = base.String()
+	if c.isCompleted(base.String()) {
+		c.log(slog.LevelDebug, "skipping site already checked", "url", base.String())
+		return nil
+	}
+	resuming := c.canResume(base)
 	c.BaseURL = base
+	c.pathPrefix = c.PathPrefix
+	if c.pathPrefix == "" && c.SamePathPrefix {
+		c.pathPrefix = startPathPrefix(base)
+	}
 	if !strings.HasSuffix(site, "/") {
 		site += "/"
+		c.addAlias(site, base.String())
+	}
+	c.markVisited(site)
+	if resuming {
+		c.crawlPending(ctx)
+	} else {
+		if c.SeedFromSitemaps {
+			c.seedFromSitemaps(ctx, base)
+		}
+		c.Crawl(ctx, base, "START")
+	}
+	if ctx.Err() == nil {
+		c.markCompleted(base.String())
 	}
-	c.visited[site] = true
-	c.Crawl(ctx, base, "START")
+	if res, ok := c.startResult(base.String()); ok && res.Status == StatusError {
+		return fmt.Errorf("could not reach start URL %s: %s", base, res.Message)
+	}
+	return nil
 }
 
-func (c *Checker) Crawl(ctx context.Context, page *url.URL, referrer string) {
-	c.Limiter.Wait(ctx)
-	req, err := http.NewRequest("GET", page.String(), nil)
+// CheckResults is like Check, but also returns the results recorded while
+// checking site, for callers that don't need them as they arrive. They're
+// still added to the checker's results, too.
+func (c *Checker) CheckResults(ctx context.Context, site string) ([]Result, error) {
+	c.mu.Lock()
+	start := len(c.results)
+	c.mu.Unlock()
+	err := c.Check(ctx, site)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.resultsLocked()[start:], err
+}
+
+// CheckAll checks each of the given sites in turn. Pages visited while
+// checking one site are not checked again for another. The errors from
+// checking each site are joined together.
+func (c *Checker) CheckAll(ctx context.Context, sites ...string) error {
+	var errs []error
+	for _, site := range sites {
+		if ctx.Err() != nil || c.overByteCap() {
+			break
+		}
+		errs = append(errs, c.Check(ctx, site))
+	}
+	return errors.Join(errs...)
+}
+
+// Recheck checks again each link which was broken, or had a warning, in
+// previous, such as the results of an earlier run read by ReadResults, to
+// confirm whether it's been fixed. Each link is checked without following any
+// links, and reported with the referrer it had before. Links which were OK or
+// skipped before aren't checked, and nor are any links added since. In
+// DryRun mode, it just lists the links it would recheck.
+func (c *Checker) Recheck(ctx context.Context, previous []Result) error {
+	if c.DryRun {
+		for _, res := range previous {
+			if res.Status == StatusError || res.Status == StatusWarning {
+				fmt.Fprintf(c.Output, "[DRY RUN] would recheck %s\n", res.Link)
+			}
+		}
+		return nil
+	}
+	ctx, end := c.begin(ctx)
+	defer end()
+	if err := c.logIn(ctx); err != nil {
+		return err
+	}
+	for _, res := range previous {
+		if res.Status != StatusError && res.Status != StatusWarning {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.checkLink(ctx, res.Link, res.Referrer)
+	}
+	return nil
+}
+
+// Login describes how to log in to a site by submitting a form, so that pages
+// which require a login can be checked. The form's fields are posted to URL,
+// with User and Password as the values of the fields named UserField and
+// PassField, and any other Fields as given.
+type Login struct {
+	URL       string
+	UserField string
+	PassField string
+	User      string
+	Password  string
+	Fields    map[string]string
+}
+
+// logIn submits the Login form, if set and not already submitted, keeping the
+// session cookies it returns in the client's cookie jar (creating one if
+// necessary) for the requests that follow.
+func (c *Checker) logIn(ctx context.Context) error {
+	if c.Login == nil || c.loggedIn {
+		return nil
+	}
+	if c.client.Jar == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return err
+		}
+		c.client.Jar = jar
+	}
+	form := url.Values{}
+	for name, value := range c.Login.Fields {
+		form.Set(name, value)
+	}
+	form.Set(c.Login.UserField, c.Login.User)
+	form.Set(c.Login.PassField, c.Login.Password)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Login.URL, strings.NewReader(form.Encode()))
 	if err != nil {
-		c.RecordResult(page.String(), referrer, err, nil)
-		return
+		return fmt.Errorf("logging in: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", fakeUserAgent)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("logging in: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("logging in at %s: %s", c.Login.URL, resp.Status)
+	}
+	c.loggedIn = true
+	c.log(slog.LevelInfo, fmt.Sprintf("logged in at %s", c.Login.URL))
+	return nil
+}
+
+// WaitForServer polls site until its server returns a response, whatever
+// the status, or until timeout has elapsed, in which case it returns an
+// error. This is useful when the server has only just been started, as in CI.
+// If site has no scheme, a response over either https or http will do.
+func (c *Checker) WaitForServer(ctx context.Context, site string, timeout time.Duration) error {
+	base, defaulted, err := parseStartURL(site)
+	if err != nil {
+		return fmt.Errorf("invalid start URL %q: %w", site, err)
+	}
+	candidates := []string{base.String()}
+	if defaulted {
+		fallback := *base
+		fallback.Scheme = "http"
+		candidates = append(candidates, fallback.String())
+	}
+	c.configureTransport()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		for _, link := range candidates {
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, link, nil)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("User-Agent", fakeUserAgent)
+			resp, err := c.client.Do(req)
+			if err == nil {
+				resp.Body.Close()
+				return nil
+			}
+			c.log(slog.LevelDebug, "waiting for server", "url", link, "error", err)
+		}
+		if err := sleep(ctx, waitPollInterval); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return fmt.Errorf("timed out after %s waiting for %s to respond", timeout, base)
+			}
+			return err
+		}
+	}
+}
+
+// parseStartURL parses site as an HTTP or HTTPS URL. If it has no scheme, as
+// with a bare domain such as "example.com", the scheme defaults to https, and
+// defaulted is true.
+func parseStartURL(site string) (base *url.URL, defaulted bool, err error) {
+	if !strings.Contains(site, "://") && !strings.HasPrefix(site, "//") {
+		site = "//" + site
+	}
+	base, err = url.Parse(site)
+	if err != nil {
+		return nil, false, err
+	}
+	if base.Scheme == "" {
+		base.Scheme = "https"
+		defaulted = true
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, false, fmt.Errorf("unsupported scheme %q (want http or https)", base.Scheme)
+	}
+	if base.Host == "" {
+		return nil, false, errors.New("missing host")
+	}
+	return base, defaulted, nil
+}
+
+// fallBackToHTTP returns base unchanged if the server answers over https, or
+// otherwise a copy of base with the scheme changed to http.
+func (c *Checker) fallBackToHTTP(ctx context.Context, base *url.URL) *url.URL {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, base.String(), nil)
+	if err != nil {
+		return base
 	}
 	req.Header.Set("User-Agent", fakeUserAgent)
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		return base
+	}
+	fallback := *base
+	fallback.Scheme = "http"
+	c.log(slog.LevelInfo, fmt.Sprintf("%s not reachable over https, trying http", base.Host), "error", err)
+	return &fallback
+}
+
+// CheckOne checks the single link rawURL and returns the result, without
+// following any links, and without adding it to the results.
+func (c *Checker) CheckOne(ctx context.Context, rawURL string) Result {
+	if u, err := url.Parse(rawURL); err == nil {
+		if handler, ok := c.schemeHandler(u); ok {
+			return c.handleScheme(ctx, handler, u, "")
+		}
+	}
+	c.configureTransport()
+	resp, err := c.fetchLink(ctx, rawURL)
+	if err != nil {
+		return c.classify(rawURL, "", err, nil)
+	}
+	defer resp.Body.Close()
+	return c.classify(rawURL, "", nil, resp)
+}
+
+// startResult returns the result recorded for the start page link, if any.
+func (c *Checker) startResult(link string) (Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, res := range c.results {
+		if res.Link == link && res.Referrer == "START" {
+			return res, true
+		}
+	}
+	return Result{}, false
+}
+
+// canResume reports whether there is pending work left over from an
+// interrupted check of base.
+func (c *Checker) canResume(base *url.URL) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending) > 0 && c.BaseURL != nil && c.BaseURL.String() == base.String()
+}
+
+func (c *Checker) isCompleted(site string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.completed[site]
+}
+
+func (c *Checker) markCompleted(site string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.completed[site] = true
+}
+
+// begin prepares the checker for a run, returning a context for the run,
+// which is cancelled early in FailFast mode, and a function to be called when
+// the run ends.
+func (c *Checker) begin(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	c.mu.Lock()
+	if c.started.IsZero() {
+		c.started = time.Now()
+	}
+	if c.failed {
+		cancel()
+	}
+	c.cancel = cancel
+	c.mu.Unlock()
+	c.configureTransport()
+	stopProgress := func() {}
+	if c.Progress != nil {
+		stopProgress = c.startProgress()
+	}
+	return ctx, func() {
+		stopProgress()
+		cancel()
+		c.mu.Lock()
+		c.finished = time.Now()
+		c.cancel = nil
+		c.mu.Unlock()
+	}
+}
+
+// describe prints what Check would do for site, without making any
+// requests.
+func (c *Checker) describe(site string, base *url.URL, err error) {
+	if err != nil {
+		fmt.Fprintf(c.Output, "[DRY RUN] invalid start URL %q: %v\n", site, err)
+		return
+	}
+	fmt.Fprintf(c.Output, "[DRY RUN] would check %s, following links on %s\n", base, base.Host)
+	fmt.Fprintf(c.Output, "[DRY RUN] first URLs to visit:\n")
+	for _, link := range c.firstURLs(base) {
+		fmt.Fprintf(c.Output, "[DRY RUN]   %s\n", link)
+	}
+	if !c.CheckExternal {
+		fmt.Fprintf(c.Output, "[DRY RUN] links to other hosts will be skipped\n")
+	}
+	if c.PathPrefix != "" || c.SamePathPrefix {
+		prefix := c.PathPrefix
+		if prefix == "" {
+			prefix = startPathPrefix(base)
+		}
+		fmt.Fprintf(c.Output, "[DRY RUN] only pages under %s will be crawled\n", prefix)
+	}
+	if c.ExternalOnly {
+		fmt.Fprintf(c.Output, "[DRY RUN] only links to other hosts will be reported\n")
+	}
+	if c.FixedDelay > 0 {
+		fmt.Fprintf(c.Output, "[DRY RUN] delay between requests: %s\n", c.FixedDelay)
+	} else {
+		fmt.Fprintf(c.Output, "[DRY RUN] rate limit: %.2fr/s\n", c.Limiter.Limit())
+	}
+	if c.Proxy != nil {
+		fmt.Fprintf(c.Output, "[DRY RUN] proxy: %s\n", c.Proxy)
+	}
+}
+
+// dryRunBatch is the number of URLs a dry run lists as the first to visit.
+const dryRunBatch = 10
+
+// firstURLs returns the URLs a check of base would visit first, up to
+// dryRunBatch of them: the links left over from an interrupted check, in the
+// order they'd be visited, or otherwise just base itself, since any others
+// can't be known without fetching it.
+func (c *Checker) firstURLs(base *url.URL) []string {
+	if !c.canResume(base) {
+		return []string{base.String()}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var links []string
+	for i := range min(len(c.pending), dryRunBatch) {
+		item := c.pending[len(c.pending)-1-i]
+		if c.Strategy == StrategyBFS {
+			item = c.pending[i]
+		}
+		links = append(links, item.URL)
+	}
+	return links
+}
+
+// configureTransport makes the client used for requests: a copy of
+// HTTPClient, with its own copy of the transport, to which the
+// transport-level options are applied, so that a shared client such as
+// http.DefaultClient is left unchanged. The copy is made once for each
+// HTTPClient, or again after Reset.
+//
+// Proxy, if set, overrides any proxy configured by the environment.
+// ResolveOverrides, if set, replaces the transport's DialContext, and
+// InsecureSkipVerify, if set, disables TLS certificate verification. These
+// have no effect on a transport that is not an *http.Transport. A client with
+// no redirect policy of its own is given one that enforces MaxRedirects,
+// whatever its transport.
+func (c *Checker) configureTransport() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil && c.clientFrom == c.HTTPClient {
+		return
+	}
+	client := *c.HTTPClient
+	c.client, c.clientFrom = &client, c.HTTPClient
+	if client.CheckRedirect == nil {
+		client.CheckRedirect = c.limitRedirects
+	}
+	if client.Transport == nil {
+		client.Transport = http.DefaultTransport
+	}
+	t, ok := client.Transport.(*http.Transport)
+	if !ok {
+		c.log(slog.LevelWarn, "custom transport in use; ignoring transport options")
+		return
+	}
+	t = t.Clone()
+	client.Transport = t
+	if c.Proxy != nil {
+		t.Proxy = http.ProxyURL(c.Proxy)
+	}
+	if len(c.ResolveOverrides) > 0 {
+		t.DialContext = c.dialWithOverrides
+	}
+	if c.InsecureSkipVerify {
+		cfg := t.TLSClientConfig.Clone()
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		cfg.InsecureSkipVerify = true
+		t.TLSClientConfig = cfg
+	}
+	// compressed responses are decoded by decodeBody
+	t.DisableCompression = true
+}
+
+// limitRedirects is an http.Client CheckRedirect policy that stops following
+// redirects once there have been more than MaxRedirects of them.
+func (c *Checker) limitRedirects(req *http.Request, via []*http.Request) error {
+	if len(via) > c.MaxRedirects {
+		return errTooManyRedirects
+	}
+	return nil
+}
+
+// dialWithOverrides connects to addr, using the IP address given for its host
+// in ResolveOverrides, if any, instead of looking it up. The request's Host
+// header and TLS server name are unaffected.
+func (c *Checker) dialWithOverrides(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err == nil {
+		for name, ip := range c.ResolveOverrides {
+			if strings.EqualFold(name, host) {
+				addr = net.JoinHostPort(ip, port)
+				break
+			}
+		}
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// Crawl checks page, then every page reachable from it that hasn't already
+// been visited, in depth-first order, or breadth-first if Strategy is
+// StrategyBFS.
+func (c *Checker) Crawl(ctx context.Context, page *url.URL, referrer string) {
+	c.addReferrer(page.String(), referrer)
+	c.markVisited(page.String())
+	c.visit(ctx, page, referrer)
+	c.crawlPending(ctx)
+}
+
+func (c *Checker) crawlPending(ctx context.Context) {
+	for ctx.Err() == nil && !c.overByteCap() {
+		item, ok := c.pop()
+		if !ok {
+			return
+		}
+		c.addReferrer(item.URL, item.Referrer)
+		c.addDiscovery(item.URL, discovery{kind: item.Kind, depth: item.Depth, protocolRelative: item.ProtocolRelative})
+		page, err := url.Parse(item.URL)
+		if err != nil {
+			c.RecordResult(item.URL, item.Referrer, err, nil)
+			continue
+		}
+		if c.markVisited(page.String()) {
+			c.visit(ctx, page, item.Referrer)
+		}
+	}
+}
+
+// visit checks a single page and, if it's on the site being checked,
+// queues the links it contains.
+func (c *Checker) visit(ctx context.Context, page *url.URL, referrer string) {
+	if c.WarnPrivateTargets && isPrivateHost(page.Hostname()) && !c.isPrivateSite() {
+		c.report(Result{
+			Link:     page.String(),
+			Status:   StatusWarning,
+			Message:  fmt.Sprintf("link to private address %s on public site, not checked", page.Hostname()),
+			Referrer: referrer,
+		})
+		return
+	}
+	if !c.CheckExternal && c.isExternal(page) {
+		c.report(Result{
+			Link:     page.String(),
+			Status:   StatusSkipped,
+			Message:  externalSkipMessage,
+			Referrer: referrer,
+		})
+		return
+	}
+	if !c.isExternal(page) && c.isCrawlerTrap(page) {
+		c.report(Result{
+			Link:     page.String(),
+			Status:   StatusSkipped,
+			Message:  "possible crawler trap",
+			Referrer: referrer,
+		})
+		return
+	}
+	if !c.isExternal(page) && !c.allowQueryVariant(page) {
+		c.report(Result{
+			Link:     page.String(),
+			Status:   StatusSkipped,
+			Message:  "query variant cap reached",
+			Referrer: referrer,
+		})
+		return
+	}
+	if handler, ok := c.schemeHandler(page); ok {
+		c.report(c.handleScheme(ctx, handler, page, referrer))
+		return
+	}
+	crawl := !c.isExternal(page) && c.inScope(page)
+	var resp *http.Response
+	var elapsed time.Duration
+	var err error
+	if c.headFirst(page.String()) || (!c.isExternal(page) && !crawl) || c.discoveryOf(page.String()).kind == ogImageKind {
+		resp, elapsed, err = c.fetchHead(ctx, page.String())
+		headOK := err == nil && (resp.Request == nil || resp.Request.Method == http.MethodHead)
+		if headOK && c.needsBody(crawl, resp) {
+			resp.Body.Close()
+			resp, elapsed, err = c.fetch(ctx, http.MethodGet, page.String())
+		}
+	} else {
+		resp, elapsed, err = c.fetch(ctx, http.MethodGet, page.String())
+	}
+	if err != nil && ctx.Err() != nil {
+		c.requeue(page, referrer)
+		return
+	}
 	if err != nil {
-		c.RecordResult(page.String(), referrer, err, resp)
+		c.reportPage(page, c.classify(page.String(), referrer, err, nil))
 		return
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusTooManyRequests {
-		c.Limiter.ReduceLimit()
-		if c.Verbose {
-			fmt.Fprintf(c.Output, "[INFO] reducing rate limit to %.2fr/s\n", c.Limiter.Limit())
+	res := c.classify(page.String(), referrer, nil, resp)
+	c.checkCertExpiry(page, &res, resp)
+	if len(c.BodyMatchers) > 0 && res.Status == StatusOK {
+		c.checkBodyMatchers(&res, resp)
+	}
+	if c.isExternal(page) {
+		if c.MaxResponseTime > 0 && elapsed > c.MaxResponseTime && res.Status == StatusOK {
+			res.Status = StatusWarning
+			res.Message += fmt.Sprintf(", but slow response: %.1fs", elapsed.Seconds())
 		}
-		c.Crawl(ctx, page, referrer)
+		c.reportPage(page, res)
+		c.log(slog.LevelDebug, "not parsing offsite page", "url", page.String())
+		return
+	}
+	if !crawl {
+		c.reportPage(page, res)
+		c.log(slog.LevelDebug, "not parsing page outside path prefix", "url", page.String(), "prefix", c.pathPrefix)
 		return
 	}
-	if c.Limiter.GraduallyIncreaseRateLimit() && c.Verbose {
-		fmt.Fprintf(c.Output, "[INFO] increasing rate limit to %.2fr/s\n", c.Limiter.Limit())
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "" && !isHTML(contentType) {
+		c.reportPage(page, res)
+		c.log(slog.LevelDebug, "not parsing non-HTML page", "url", page.String(), "content_type", contentType)
+		return
+	}
+	data, err := c.readBody(ctx, resp)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		c.requeue(page, referrer)
+		return
+	case errors.Is(err, errBodyTooLarge):
+		res.Status = StatusWarning
+		res.Message += fmt.Sprintf(", but page is larger than %d bytes; links beyond that were not checked", c.MaxBodySize)
+	case errors.Is(err, context.DeadlineExceeded), os.IsTimeout(err):
+		res.Status = StatusError
+		res.ErrorKind = ErrorKindTimeout
+		res.Message += ", but timed out reading page"
+		c.reportPage(page, res)
+		return
+	case err != nil:
+		c.reportPage(page, res)
+		c.log(slog.LevelDebug, "skipping unreadable page", "url", page.String(), "error", err)
+		return
+	}
+	if contentType == "" && !isHTML(http.DetectContentType(data)) {
+		c.reportPage(page, res)
+		c.log(slog.LevelDebug, "not parsing non-HTML page", "url", page.String(), "content_type", "")
+		return
+	}
+	if res.Status == StatusOK {
+		c.checkSoftNotFound(&res, data)
 	}
-	c.RecordResult(page.String(), referrer, err, resp)
-	if page.Host != c.BaseURL.Host {
-		return // skip parsing offsite pages
+	doc, err := htmlquery.Parse(bytes.NewReader(data))
+	if err == nil && res.Status == StatusOK {
+		checkCanonical(&res, doc, page)
 	}
-	doc, err := htmlquery.Parse(resp.Body)
+	c.reportPage(page, res)
 	if err != nil {
-		return // skip invalid HTML
+		c.log(slog.LevelDebug, "skipping invalid HTML", "url", page.String(), "error", err)
+		return
+	}
+	if c.CheckMixedContent && page.Scheme == "https" {
+		c.checkMixedContent(doc, page)
 	}
-	list := htmlquery.Find(doc, "//a/@href")
-	for _, anchor := range list {
-		link := htmlquery.SelectAttr(anchor, "href")
+	if !c.IgnoreRobotsTag && robotsNoFollow(resp.Header) {
+		c.log(slog.LevelDebug, "not following links on nofollow page", "url", page.String())
+		return
+	}
+	var links []crawlItem
+	depth := c.discoveryOf(page.String()).depth + 1
+	for _, found := range findLinks(doc, c.linkSelectorExpr()) {
+		link := cleanHref(found.href)
 		u, err := url.Parse(link)
 		if err != nil {
-			c.RecordResult(link, page.String(), err, nil)
-			return
+			// queued as-is, so that the error is reported in order
+			links = append(links, crawlItem{URL: link, Referrer: page.String(), Kind: found.kind, Depth: depth})
+			break
 		}
 		if u.Scheme == "mailto" {
+			c.log(slog.LevelDebug, "skipping mailto link", "link", link)
 			continue
 		}
 		target := page.ResolveReference(u)
-		if !c.visited[target.String()] {
-			c.visited[target.String()] = true
-			c.Crawl(ctx, target, page.String())
+		links = append(links, crawlItem{
+			URL:              target.String(),
+			Referrer:         page.String(),
+			Kind:             found.kind,
+			Depth:            depth,
+			ProtocolRelative: u.Scheme == "" && u.Host != "",
+		})
+	}
+	c.push(links...)
+}
+
+// A SchemeHandler checks a link whose scheme weaver doesn't support itself,
+// such as ftp, and returns the result. The result's Link and Referrer are
+// filled in automatically.
+type SchemeHandler func(ctx context.Context, u *url.URL) Result
+
+// schemeHandler returns the handler registered in SchemeHandlers for the
+// scheme of u, if any. HTTP and HTTPS links are always checked by weaver
+// itself.
+func (c *Checker) schemeHandler(u *url.URL) (SchemeHandler, bool) {
+	if u.Scheme == "http" || u.Scheme == "https" {
+		return nil, false
+	}
+	handler, ok := c.SchemeHandlers[u.Scheme]
+	return handler, ok && handler != nil
+}
+
+// handleScheme checks u with handler, returning the result.
+func (c *Checker) handleScheme(ctx context.Context, handler SchemeHandler, u *url.URL, referrer string) Result {
+	c.log(slog.LevelDebug, "checking link with scheme handler", "url", u.String(), "scheme", u.Scheme)
+	res := handler(ctx, u)
+	res.Link = u.String()
+	res.Referrer = referrer
+	return res
+}
+
+// ExternalPolicy configures how links to other sites are checked, as opposed
+// to links within the site being crawled, which use the checker's own
+// settings. Each request for an external link times out after Timeout, if
+// it's positive, and is retried at most MaxRetries times, for server errors,
+// connection resets, and rate limiting alike. Rate limiting by other sites
+// doesn't slow down the rest of the crawl. Method is the HTTP method used to
+// check external links, either "HEAD" (falling back to GET if the server
+// doesn't allow HEAD) or "GET"; if it's empty, HeadFirst applies as usual.
+type ExternalPolicy struct {
+	Timeout    time.Duration
+	MaxRetries int
+	Method     string
+}
+
+// externalPolicy returns the ExternalPolicy to use for link, or nil if link
+// isn't external, or there's no policy. Links aren't considered external
+// unless a site is being crawled.
+func (c *Checker) externalPolicy(link string) *ExternalPolicy {
+	if c.ExternalPolicy == nil || c.BaseURL == nil {
+		return nil
+	}
+	u, err := url.Parse(link)
+	if err != nil || !c.isExternal(u) {
+		return nil
+	}
+	return c.ExternalPolicy
+}
+
+// headFirst reports whether link should be checked with a HEAD request
+// before any GET request.
+func (c *Checker) headFirst(link string) bool {
+	if p := c.externalPolicy(link); p != nil && p.Method != "" {
+		return p.Method == http.MethodHead
+	}
+	return c.HeadFirst
+}
+
+// robotsNoFollow reports whether the X-Robots-Tag headers in h tell crawlers
+// not to follow the links on the page, with "nofollow" or "none". Directives
+// addressed to a particular user agent, such as "googlebot: nofollow", are
+// ignored unless they're addressed to weaver.
+func robotsNoFollow(h http.Header) bool {
+	for _, v := range h.Values("X-Robots-Tag") {
+		agent, rest, ok := strings.Cut(v, ":")
+		if ok && !strings.Contains(agent, ",") && !strings.EqualFold(strings.TrimSpace(agent), "unavailable_after") {
+			if !strings.EqualFold(strings.TrimSpace(agent), "weaver") {
+				continue
+			}
+			v = rest
+		}
+		for _, directive := range strings.Split(v, ",") {
+			switch strings.ToLower(strings.TrimSpace(directive)) {
+			case "nofollow", "none":
+				return true
+			}
 		}
 	}
+	return false
 }
 
-func (c *Checker) RecordResult(link, referrer string, err error, resp *http.Response) {
-	res := Result{
-		Status:   StatusError,
-		Link:     link,
-		Referrer: referrer,
+// isPrivateHost reports whether host is "localhost", or a loopback, private,
+// link-local, or unspecified IP address, none of which a visitor to a public
+// site could reach.
+func isPrivateHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified()
+}
+
+// isPrivateSite reports whether the site being crawled is itself on a
+// private address, such as a development server, in which case links to
+// private addresses are to be expected.
+func (c *Checker) isPrivateSite() bool {
+	return c.BaseURL == nil || isPrivateHost(c.BaseURL.Hostname())
+}
+
+// requeue puts page back on the pending stack after the crawl was cancelled
+// while checking it, so that it's checked if the crawl is resumed.
+func (c *Checker) requeue(page *url.URL, referrer string) {
+	c.unvisit(page.String())
+	d := c.discoveryOf(page.String())
+	c.push(crawlItem{
+		URL:              page.String(),
+		Referrer:         referrer,
+		Kind:             d.kind,
+		Depth:            d.depth,
+		ProtocolRelative: d.protocolRelative,
+	})
+}
+
+// DefaultLinkSelectors is the default value of LinkSelectors: links and image
+// map areas.
+var DefaultLinkSelectors = []string{"//a/@href", "//area/@href"}
+
+// FormLinkSelector selects the actions of forms submitted with GET, which can
+// be checked as ordinary links. Add it to LinkSelectors to check them.
+const FormLinkSelector = "//form[not(@method) or translate(@method, 'GET', 'get') = 'get']/@action"
+
+// OpenGraphImageSelector selects the social preview images declared by Open
+// Graph and Twitter meta tags, which are never seen by visitors to the page,
+// so tend to break unnoticed. Add it to LinkSelectors to check them. They're
+// checked with HEAD requests, and recorded with the Kind "og:image".
+const OpenGraphImageSelector = "//meta[@property='og:image' or @property='og:image:url' or @property='og:image:secure_url' or @name='twitter:image' or @property='twitter:image']/@content"
+
+// ogImageKind is the Kind of links found by OpenGraphImageSelector.
+const ogImageKind = "og:image"
+
+var defaultLinkSelector = xpath.MustCompile(strings.Join(DefaultLinkSelectors, " | "))
+
+// foundLink is a link found in a document, and the kind of element it was
+// found in, such as "a".
+type foundLink struct {
+	href, kind string
+}
+
+// findLinks returns the links in doc selected by expr, in document order.
+func findLinks(doc *html.Node, expr *xpath.Expr) []foundLink {
+	var links []foundLink
+	iter := expr.Select(htmlquery.CreateXPathNavigator(doc))
+	for iter.MoveNext() {
+		nav, ok := iter.Current().(*htmlquery.NodeNavigator)
+		if !ok {
+			continue
+		}
+		links = append(links, foundLink{href: nav.Value(), kind: linkKind(nav.Current())})
+	}
+	return links
+}
+
+// linkKind returns the kind of element n, containing a link, is: usually its
+// name, such as "a", but "og:image" for the meta tags selected by
+// OpenGraphImageSelector.
+func linkKind(n *html.Node) string {
+	if n.Data != "meta" {
+		return n.Data
+	}
+	for _, attr := range n.Attr {
+		if attr.Key != "property" && attr.Key != "name" {
+			continue
+		}
+		switch attr.Val {
+		case "og:image", "og:image:url", "og:image:secure_url", "twitter:image":
+			return ogImageKind
+		}
+	}
+	return n.Data
+}
+
+// compileLinkSelectors checks that each of the LinkSelectors is a valid XPath
+// expression, and compiles them all into a single expression, which matches
+// links in document order. If LinkSelectors is empty, DefaultLinkSelectors
+// is used.
+func (c *Checker) compileLinkSelectors() error {
+	selectors := c.LinkSelectors
+	if len(selectors) == 0 {
+		selectors = DefaultLinkSelectors
+	}
+	for _, sel := range selectors {
+		if _, err := xpath.Compile(sel); err != nil {
+			return fmt.Errorf("invalid link selector %q: %w", sel, err)
+		}
 	}
+	expr, err := xpath.Compile(strings.Join(selectors, " | "))
 	if err != nil {
-		res.Message = err.Error()
-		var e *tls.CertificateVerificationError
-		if errors.As(err, &e) {
-			res.Status = StatusWarning
+		return fmt.Errorf("invalid link selectors %q: %w", selectors, err)
+	}
+	c.linkSelector = expr
+	return nil
+}
+
+// linkSelectorExpr returns the compiled LinkSelectors, or the default
+// selector if they haven't been compiled.
+func (c *Checker) linkSelectorExpr() *xpath.Expr {
+	if c.linkSelector == nil {
+		return defaultLinkSelector
+	}
+	return c.linkSelector
+}
+
+// cleanHref tidies up the link href as browsers do, before it's parsed:
+// leading and trailing whitespace is removed, as are any tabs and newlines,
+// and backslashes before any query or fragment are treated as forward
+// slashes.
+func cleanHref(href string) string {
+	href = strings.TrimSpace(href)
+	href = strings.NewReplacer("\t", "", "\n", "", "\r", "").Replace(href)
+	end := strings.IndexAny(href, "?#")
+	if end < 0 {
+		end = len(href)
+	}
+	return strings.ReplaceAll(href[:end], `\`, "/") + href[end:]
+}
+
+// fetchLink requests link just to check it, with HEAD if HeadFirst is set,
+// or otherwise GET.
+func (c *Checker) fetchLink(ctx context.Context, link string) (*http.Response, error) {
+	if c.headFirst(link) {
+		resp, _, err := c.fetchHead(ctx, link)
+		return resp, err
+	}
+	resp, _, err := c.fetch(ctx, http.MethodGet, link)
+	return resp, err
+}
+
+// fetchHead requests link with HEAD, falling back to GET if the server
+// doesn't allow HEAD requests.
+func (c *Checker) fetchHead(ctx context.Context, link string) (*http.Response, time.Duration, error) {
+	resp, elapsed, err := c.fetch(ctx, http.MethodHead, link)
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		resp.Body.Close()
+		return c.fetch(ctx, http.MethodGet, link)
+	}
+	return resp, elapsed, err
+}
+
+// needsBody reports whether the body of the page whose HEAD response is resp
+// is needed: that is, if it's an HTML page to be crawled, or any of the
+// BodyMatchers apply to it.
+func (c *Checker) needsBody(crawl bool, resp *http.Response) bool {
+	contentType := resp.Header.Get("Content-Type")
+	if crawl && (contentType == "" || isHTML(contentType)) {
+		return true
+	}
+	for _, m := range c.BodyMatchers {
+		if m.ContentType == nil || m.ContentType.MatchString(contentType) {
+			return true
 		}
-		fmt.Fprintln(c.Output, res)
-		c.results = append(c.results, res)
-		return
 	}
-	res.Message = resp.Status
-	switch resp.StatusCode {
-	case http.StatusOK:
-		res.Status = StatusOK
-	case http.StatusNotFound,
-		http.StatusNotAcceptable,
-		http.StatusGone,
-		http.StatusUnauthorized,
-		http.StatusBadRequest,
-		http.StatusForbidden:
-		res.Status = StatusError
-	default:
-		res.Status = StatusWarning
+	return false
+}
+
+// reportPage reports the result of checking page. In ExternalOnly mode, pages
+// on the site being checked are recorded as skipped.
+func (c *Checker) reportPage(page *url.URL, res Result) {
+	if c.ExternalOnly && !c.isExternal(page) {
+		res.Status = StatusSkipped
+		res.Message = "internal page not reported"
+	}
+	c.report(res)
+}
+
+// fetch requests link, retrying according to the retry settings, and returns
+// the response and how long it took to arrive.
+func (c *Checker) fetch(ctx context.Context, method, link string) (*http.Response, time.Duration, error) {
+	if c.PerURLBudget <= 0 {
+		return c.fetchWithRetries(ctx, method, link)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.PerURLBudget)
+	resp, elapsed, err := c.fetchWithRetries(ctx, method, link)
+	if err != nil {
+		cancel()
+		return nil, 0, err
+	}
+	resp.Body = releasingBody{ReadCloser: resp.Body, release: cancel}
+	return resp, elapsed, nil
+}
+
+// fetchWithRetries does the work of fetch, without the PerURLBudget.
+func (c *Checker) fetchWithRetries(ctx context.Context, method, link string) (*http.Response, time.Duration, error) {
+	rateLimitRetries, retries := 0, 0
+	maxRetries, maxRateLimitRetries, adapt := c.MaxRetries, c.MaxRateLimitRetries, c.FixedDelay <= 0
+	policy := c.externalPolicy(link)
+	if policy != nil {
+		maxRetries, maxRateLimitRetries, adapt = policy.MaxRetries, policy.MaxRetries, false
+	}
+	for {
+		req, err := http.NewRequestWithContext(ctx, method, link, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		if c.circuitOpen(req.URL.Host) {
+			return nil, 0, errCircuitOpen
+		}
+		if err := c.wait(ctx); err != nil {
+			return nil, 0, err
+		}
+		req.Header.Set("User-Agent", fakeUserAgent)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		if c.Accept != "" {
+			req.Header.Set("Accept", c.Accept)
+		}
+		if c.AcceptLanguage != "" {
+			req.Header.Set("Accept-Language", c.AcceptLanguage)
+		}
+		if c.Cache != nil {
+			c.Cache.addValidators(req)
+		}
+		if c.BeforeRequest != nil {
+			if err := c.BeforeRequest(req); err != nil {
+				return nil, 0, err
+			}
+		}
+		cancelReq := context.CancelFunc(func() {})
+		if policy != nil && policy.Timeout > 0 {
+			var reqCtx context.Context
+			reqCtx, cancelReq = context.WithTimeout(ctx, policy.Timeout)
+			req = req.WithContext(reqCtx)
+		}
+		start := time.Now()
+		releaseHost, err := c.acquireHost(ctx, req.URL.Host)
+		if err != nil {
+			cancelReq()
+			return nil, 0, err
+		}
+		release := func() {
+			releaseHost()
+			cancelReq()
+		}
+		c.log(slog.LevelDebug, "sending request", "method", method, "url", link)
+		resp, err := c.client.Do(req)
+		elapsed := time.Since(start)
+		c.recordRequest(elapsed)
+		if ctx.Err() == nil && req.Context().Err() == nil {
+			c.recordHostResult(req.URL.Host, err)
+		}
+		if err != nil {
+			release()
+			if c.RetryOnReset && isConnReset(err) && retries < maxRetries {
+				backoff := c.RetryBackoff << retries
+				retries++
+				c.log(slog.LevelDebug, "retrying after connection reset", "url", link, "error", err, "backoff", backoff)
+				if err := sleep(ctx, backoff); err != nil {
+					return nil, 0, err
+				}
+				continue
+			}
+			return nil, 0, err
+		}
+		resp.Body = releasingBody{ReadCloser: resp.Body, release: release}
+		c.log(slog.LevelDebug, "received response", "url", link, "status", resp.StatusCode, "elapsed", elapsed)
+		c.log(levelTrace, "response headers", "url", link, "headers", resp.Header)
+		if slices.Contains(c.RetryStatusCodes, resp.StatusCode) && retries < maxRetries {
+			resp.Body.Close()
+			backoff := c.RetryBackoff << retries
+			retries++
+			c.log(slog.LevelDebug, "retrying after server error", "url", link, "status", resp.StatusCode, "backoff", backoff)
+			if err := sleep(ctx, backoff); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			if adapt && c.Limiter.GraduallyIncreaseRateLimit() {
+				limit := c.Limiter.Limit()
+				c.log(slog.LevelInfo, fmt.Sprintf("increasing rate limit to %.2fr/s", limit), "limit", float64(limit))
+			}
+			resp.Body = countingBody{ReadCloser: resp.Body, c: c}
+			decodeBody(resp)
+			if c.Cache != nil {
+				c.Cache.update(link, resp)
+			}
+			return resp, elapsed, nil
+		}
+		resp.Body.Close()
+		if rateLimitRetries >= maxRateLimitRetries {
+			return nil, 0, fmt.Errorf("%s: %w", resp.Status, errTooManyRetries)
+		}
+		rateLimitRetries++
+		if adapt {
+			atFloor := c.Limiter.ReduceLimit()
+			limit := c.Limiter.Limit()
+			if atFloor {
+				c.log(slog.LevelWarn, fmt.Sprintf("rate limit at minimum of %.2fr/s", limit), "limit", float64(limit))
+			} else {
+				c.log(slog.LevelInfo, fmt.Sprintf("reducing rate limit to %.2fr/s", limit), "limit", float64(limit))
+			}
+		}
+		c.log(slog.LevelDebug, "retrying after rate limit", "url", link)
+	}
+}
+
+// isConnReset reports whether err indicates that the connection was reset by
+// the server, or closed before the whole response was received.
+func isConnReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isHostFailure reports whether err indicates that the host couldn't be
+// reached at all: its name didn't resolve, or the connection was refused,
+// timed out, or was reset.
+func isHostFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch kind, _ := classifyError(err); kind {
+	case ErrorKindDNS, ErrorKindRefused, ErrorKindTimeout:
+		return true
+	}
+	return isConnReset(err)
+}
+
+// sleep waits for d, returning early with the context's error if ctx is
+// cancelled first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// circuitOpen reports whether requests to host are being short-circuited,
+// because the last CircuitBreakerThreshold requests to it failed, less than
+// CircuitBreakerCooldown ago.
+func (c *Checker) circuitOpen(host string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	opened, ok := c.circuits[host]
+	return ok && time.Since(opened) < c.CircuitBreakerCooldown
+}
+
+// recordHostResult counts consecutive requests to host that failed because
+// the host couldn't be reached, opening its circuit once there have been
+// CircuitBreakerThreshold of them. Any other result, such as a response or a
+// TLS error, shows that the host is up, and closes the circuit again.
+func (c *Checker) recordHostResult(host string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !isHostFailure(err) {
+		delete(c.hostFailures, host)
+		delete(c.circuits, host)
+		return
+	}
+	c.hostFailures[host]++
+	if c.CircuitBreakerThreshold > 0 && c.hostFailures[host] >= c.CircuitBreakerThreshold {
+		c.circuits[host] = time.Now()
+	}
+}
+
+// acquireHost waits until fewer than PerHostConcurrency requests to host are
+// in progress, and then claims a place for a new one, returning a function to
+// give it up again. It returns early with the context's error if ctx is
+// cancelled first. If PerHostConcurrency is zero, there's no limit.
+func (c *Checker) acquireHost(ctx context.Context, host string) (release func(), err error) {
+	if c.PerHostConcurrency <= 0 {
+		return func() {}, nil
+	}
+	c.mu.Lock()
+	slots, ok := c.hostSlots[host]
+	if !ok {
+		slots = make(chan struct{}, c.PerHostConcurrency)
+		c.hostSlots[host] = slots
+	}
+	c.mu.Unlock()
+	select {
+	case slots <- struct{}{}:
+		return sync.OnceFunc(func() { <-slots }), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// releasingBody is a response body which gives up its request's place in the
+// per-host limit when closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (rb releasingBody) Close() error {
+	err := rb.ReadCloser.Close()
+	rb.release()
+	return err
+}
+
+// wait blocks until the next request may be sent. If FixedDelay is set, that's
+// when FixedDelay has passed since the previous request; otherwise, it's up
+// to Limiter. It returns an error, without waiting any longer, if ctx is
+// cancelled first, or if its deadline would pass before the request may be
+// sent.
+func (c *Checker) wait(ctx context.Context) error {
+	if c.FixedDelay <= 0 {
+		if err := c.Limiter.Wait(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("waiting for rate limit: %w", context.DeadlineExceeded)
+		}
+		return nil
+	}
+	c.mu.Lock()
+	next := c.lastRequest.Add(c.FixedDelay)
+	c.mu.Unlock()
+	if err := sleep(ctx, time.Until(next)); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.lastRequest = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// mixedContentSelector matches the links and embedded resources in a page
+// which browsers will warn about or block if loaded over plain HTTP from an
+// HTTPS page.
+const mixedContentSelector = `//a/@href | //link/@href | //img/@src | //script/@src | //iframe/@src | //audio/@src | //video/@src | //source/@src | //embed/@src | //object/@data`
+
+// checkMixedContent records a warning for every plain HTTP URL referenced
+// by the HTTPS page.
+func (c *Checker) checkMixedContent(doc *html.Node, page *url.URL) {
+	for _, attr := range htmlquery.Find(doc, mixedContentSelector) {
+		u, err := url.Parse(htmlquery.InnerText(attr))
+		if err != nil {
+			continue
+		}
+		target := page.ResolveReference(u)
+		if target.Scheme != "http" {
+			continue
+		}
+		c.report(Result{
+			Link:     target.String(),
+			Status:   StatusWarning,
+			Message:  "mixed content: plain HTTP URL on HTTPS page",
+			Referrer: page.String(),
+		})
+	}
+}
+
+// isCrawlerTrap reports whether page's path looks like part of an infinite
+// URL space: that is, whether it has more than MaxPathSegments segments, or
+// any one segment occurs more than MaxRepeatedSegments times. A zero limit
+// disables the corresponding check.
+func (c *Checker) isCrawlerTrap(page *url.URL) bool {
+	segments := strings.FieldsFunc(page.Path, func(r rune) bool { return r == '/' })
+	if c.MaxPathSegments > 0 && len(segments) > c.MaxPathSegments {
+		return true
+	}
+	if c.MaxRepeatedSegments > 0 {
+		counts := map[string]int{}
+		for _, seg := range segments {
+			counts[seg]++
+			if counts[seg] > c.MaxRepeatedSegments {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowQueryVariant reports whether page, if it has a query string, should
+// be visited: that is, whether fewer than MaxQueryVariants pages with the
+// same path but different queries have been visited so far. If so, page is
+// counted as one of them. A MaxQueryVariants of zero or less means no limit.
+func (c *Checker) allowQueryVariant(page *url.URL) bool {
+	if c.MaxQueryVariants <= 0 || page.RawQuery == "" {
+		return true
+	}
+	key := page.Scheme + "://" + page.Host + page.Path
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.queryVariants[key] >= c.MaxQueryVariants {
+		return false
+	}
+	c.queryVariants[key]++
+	return true
+}
+
+// inScope reports whether page is within the path prefix being crawled, if
+// any.
+func (c *Checker) inScope(page *url.URL) bool {
+	if c.pathPrefix == "" {
+		return true
+	}
+	return strings.HasPrefix(page.Path, c.pathPrefix) || page.Path+"/" == c.pathPrefix
+}
+
+// startPathPrefix returns the directory containing the start page base, with
+// a trailing slash. A final path segment without an extension is assumed to
+// be a directory.
+func startPathPrefix(base *url.URL) string {
+	p := base.Path
+	switch {
+	case strings.HasSuffix(p, "/"):
+		return p
+	case path.Ext(p) != "":
+		return strings.TrimSuffix(path.Dir(p), "/") + "/"
+	}
+	return p + "/"
+}
+
+// isExternal reports whether u is on a different host from the site being
+// checked.
+func (c *Checker) isExternal(u *url.URL) bool {
+	return !sameHost(u, c.BaseURL)
+}
+
+// sameHost reports whether a and b refer to the same host and port, treating
+// internationalized domain names as equal to their punycode forms, and IPv6
+// addresses as equal however they're written.
+func sameHost(a, b *url.URL) bool {
+	return hostKey(a) == hostKey(b)
+}
+
+func hostKey(u *url.URL) string {
+	host := strings.ToLower(u.Hostname())
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	} else if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		host = ascii
+	}
+	return net.JoinHostPort(host, u.Port())
+}
+
+// push adds items to the pending stack so that the first item is popped
+// first. With StrategyBFS, pending is a queue instead, and items are added to
+// the back of it in order.
+func (c *Checker) push(items ...crawlItem) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Strategy == StrategyBFS {
+		c.pending = append(c.pending, items...)
+		return
+	}
+	for i := len(items) - 1; i >= 0; i-- {
+		c.pending = append(c.pending, items[i])
+	}
+}
+
+func (c *Checker) pop() (crawlItem, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) == 0 {
+		return crawlItem{}, false
+	}
+	if c.Strategy == StrategyBFS {
+		item := c.pending[0]
+		c.pending = c.pending[1:]
+		return item, true
+	}
+	item := c.pending[len(c.pending)-1]
+	c.pending = c.pending[:len(c.pending)-1]
+	return item, true
+}
+
+type crawlItem struct {
+	URL              string `json:"url"`
+	Referrer         string `json:"referrer"`
+	Kind             string `json:"kind,omitempty"`
+	Depth            int    `json:"depth,omitempty"`
+	ProtocolRelative bool   `json:"protocol_relative,omitempty"`
+}
+
+func (c *Checker) RecordResult(link, referrer string, err error, resp *http.Response) {
+	c.report(c.classify(link, referrer, err, resp))
+}
+
+// classify determines the status of link from the outcome of the request.
+func (c *Checker) classify(link, referrer string, err error, resp *http.Response) Result {
+	res := Result{
+		Status:   StatusError,
+		Link:     link,
+		Referrer: referrer,
+	}
+	if err != nil {
+		res.Message = err.Error()
+		var ue *url.Error
+		if errors.As(err, &ue) && ue.Op != "parse" {
+			res.Method = strings.ToUpper(ue.Op)
+		}
+		switch {
+		case errors.Is(err, ErrSkip):
+			res.Status = StatusSkipped
+		case errors.Is(err, errTooManyRetries):
+			res.Status = StatusWarning
+		case errors.Is(err, errTooManyRedirects):
+			res.Message = errTooManyRedirects.Error()
+		case ue != nil && ue.Op == "parse":
+			// a malformed link is probably a typo, not a broken page
+			res.Status = StatusWarning
+		default:
+			res.ErrorKind, res.Message = classifyError(err)
+			if res.ErrorKind == ErrorKindTLS {
+				res.Status = StatusWarning
+			}
+		}
+		return res
+	}
+	res.Message = resp.Status
+	res.StatusCode = resp.StatusCode
+	res.ContentType = mediaType(resp.Header.Get("Content-Type"))
+	if resp.Request != nil {
+		res.Method = resp.Request.Method
+		for r := resp.Request.Response; r != nil && r.Request != nil; r = r.Request.Response {
+			res.Redirects++
+		}
+	}
+	switch {
+	case slices.Contains(c.OKStatusCodes, resp.StatusCode):
+		res.Status = StatusOK
+	case resp.StatusCode == http.StatusNotModified && resp.Request != nil && isConditional(resp.Request):
+		// unchanged since it was cached
+		res.Status = StatusOK
+		res.FromCache = true
+	default:
+		classify := c.StatusClassifier
+		if classify == nil {
+			classify = DefaultStatusClassifier
+		}
+		res.Status = classify(resp.StatusCode)
+	}
+	c.checkRedirect(&res, link, resp)
+	return res
+}
+
+// mediaType returns the media type of contentType, such as "text/html",
+// without any parameters.
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.TrimSpace(contentType)
+	}
+	return mt
+}
+
+// classifyError determines what kind of failure err represents, returning a
+// message describing it.
+func classifyError(err error) (ErrorKind, string) {
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	switch {
+	case errors.As(err, &dnsErr):
+		return ErrorKindDNS, fmt.Sprintf("DNS lookup failed for %s: %s", dnsErr.Name, dnsErr.Err)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorKindRefused, "connection refused"
+	case os.IsTimeout(err), errors.Is(err, context.DeadlineExceeded):
+		return ErrorKindTimeout, "timed out waiting for response"
+	case errors.As(err, &certErr), errors.As(err, &recordErr):
+		return ErrorKindTLS, err.Error()
+	}
+	return ErrorKindOther, err.Error()
+}
+
+// checkRedirect records a warning, if WarnCrossDomainRedirect is set, when a
+// request for link that would otherwise be OK ended up on a different host.
+func (c *Checker) checkRedirect(res *Result, link string, resp *http.Response) {
+	if !c.WarnCrossDomainRedirect || res.Status != StatusOK || resp.Request == nil {
+		return
+	}
+	orig, err := url.Parse(link)
+	if err != nil || sameHost(orig, resp.Request.URL) {
+		return
+	}
+	res.Status = StatusWarning
+	res.Message += fmt.Sprintf(", but redirects off-site from %s to %s", orig.Host, resp.Request.URL.Host)
+}
+
+// DefaultStatusClassifier is the default StatusClassifier. It treats 200 as
+// OK, common client errors indicating a broken link as errors, and anything
+// else as a warning.
+func DefaultStatusClassifier(code int) Status {
+	switch code {
+	case http.StatusOK:
+		return StatusOK
+	case http.StatusNotFound,
+		http.StatusNotAcceptable,
+		http.StatusGone,
+		http.StatusUnauthorized,
+		http.StatusBadRequest,
+		http.StatusForbidden:
+		return StatusError
+	default:
+		return StatusWarning
+	}
+}
+
+var errTooManyRetries = errors.New("gave up after rate-limit retries")
+
+var errTooManyRedirects = errors.New("too many redirects")
+
+// externalSkipMessage is the message for links to other hosts which weren't
+// checked because CheckExternal is false.
+const externalSkipMessage = "external link not checked"
+
+var errCircuitOpen = errors.New("host unreachable (circuit open)")
+
+// ErrSkip can be returned by a BeforeRequest hook to skip the request. The
+// link is recorded with StatusSkipped.
+var ErrSkip = errors.New("skipped by BeforeRequest")
+
+var errBodyTooLarge = errors.New("body too large")
+
+// readBody reads at most MaxBodySize bytes of the response body, returning
+// errBodyTooLarge (along with the data read) if there was more. A
+// MaxBodySize of zero or less means no limit. If ctx is cancelled, or the
+// body takes longer than BodyTimeout to read, the body is closed, and the
+// context's error is returned.
+func (c *Checker) readBody(ctx context.Context, resp *http.Response) ([]byte, error) {
+	if c.BodyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.BodyTimeout)
+		defer cancel()
+	}
+	// closing the body unblocks any read in progress
+	stop := context.AfterFunc(ctx, func() { resp.Body.Close() })
+	defer stop()
+	var r io.Reader = resp.Body
+	if c.MaxBodySize > 0 {
+		r = io.LimitReader(resp.Body, c.MaxBodySize+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxBodySize <= 0 {
+		return data, nil
+	}
+	if int64(len(data)) > c.MaxBodySize {
+		return data[:c.MaxBodySize], errBodyTooLarge
+	}
+	return data, nil
+}
+
+// checkSoftNotFound downgrades res to a warning if the page body matches any
+// of the SoftNotFoundPatterns, indicating a missing page served with a
+// success status.
+func (c *Checker) checkSoftNotFound(res *Result, body []byte) {
+	for _, pattern := range c.SoftNotFoundPatterns {
+		if pattern.Match(body) {
+			res.Status = StatusWarning
+			res.Message += fmt.Sprintf(", but looks like a missing page (matches %q)", pattern)
+			return
+		}
+	}
+}
+
+// BodyMatcher identifies responses which are broken despite a success status,
+// such as an API returning an error message with "200 OK". If the response's
+// Content-Type matches ContentType (or ContentType is nil), and its body
+// matches Body, the result is given Status.
+type BodyMatcher struct {
+	ContentType *regexp.Regexp
+	Body        *regexp.Regexp
+	Status      Status
+}
+
+// checkBodyMatchers applies the first of the BodyMatchers which matches the
+// response, if any, to res. The body is read (up to MaxBodySize bytes), but
+// remains available to be read again.
+func (c *Checker) checkBodyMatchers(res *Result, resp *http.Response) {
+	var r io.Reader = resp.Body
+	if c.MaxBodySize > 0 {
+		r = io.LimitReader(resp.Body, c.MaxBodySize)
+	}
+	data, err := io.ReadAll(r)
+	resp.Body = decodedBody{Reader: io.MultiReader(bytes.NewReader(data), resp.Body), Closer: resp.Body}
+	if err != nil {
+		return
+	}
+	contentType := resp.Header.Get("Content-Type")
+	for _, m := range c.BodyMatchers {
+		if m.ContentType != nil && !m.ContentType.MatchString(contentType) {
+			continue
+		}
+		if m.Body != nil && m.Body.Match(data) {
+			res.Status = m.Status
+			res.Message += fmt.Sprintf(", but body matches %q", m.Body)
+			return
+		}
+	}
+}
+
+// checkCanonical records a warning if the page declares a canonical URL other
+// than its own, which usually means it duplicates another page.
+func checkCanonical(res *Result, doc *html.Node, page *url.URL) {
+	link := htmlquery.FindOne(doc, "//link[@rel='canonical']/@href")
+	if link == nil {
+		return
+	}
+	u, err := url.Parse(strings.TrimSpace(htmlquery.SelectAttr(link, "href")))
+	if err != nil {
+		return
+	}
+	canonical := normalizeURL(page.ResolveReference(u))
+	if canonical == normalizeURL(page) {
+		return
+	}
+	res.Status = StatusWarning
+	res.Message += fmt.Sprintf(", but canonical URL is %s, not %s", canonical, normalizeURL(page))
+}
+
+// normalizeURL returns u as a string with the scheme and host lowercased, an
+// internationalized host converted to punycode, the fragment removed, and an
+// empty path replaced by "/".
+func normalizeURL(u *url.URL) string {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+	n.Host = strings.ToLower(n.Host)
+	if ascii, err := idna.Lookup.ToASCII(n.Hostname()); err == nil && ascii != n.Hostname() {
+		n.Host = ascii
+		if port := u.Port(); port != "" {
+			n.Host = net.JoinHostPort(ascii, port)
+		}
+	}
+	n.Fragment = ""
+	n.RawFragment = ""
+	if n.Path == "" {
+		n.Path = "/"
+	}
+	return n.String()
+}
+
+// checkCertExpiry turns res, the result for page, into a warning if the
+// server's TLS certificate expires within CertExpiryWindow, noting when in its
+// message. Each host is checked only once.
+func (c *Checker) checkCertExpiry(page *url.URL, res *Result, resp *http.Response) {
+	if c.CertExpiryWindow <= 0 || resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return
+	}
+	c.mu.Lock()
+	checked := c.certChecked[page.Host]
+	c.certChecked[page.Host] = true
+	c.mu.Unlock()
+	if checked {
+		return
+	}
+	expiry := resp.TLS.PeerCertificates[0].NotAfter
+	remaining := time.Until(expiry)
+	if remaining > c.CertExpiryWindow {
+		return
+	}
+	if res.Status == StatusOK {
+		res.Status = StatusWarning
+	}
+	res.Message += fmt.Sprintf(", but TLS certificate for %s expires in %d days, on %s",
+		page.Host, int(remaining.Hours()/24), expiry.Format(time.DateOnly))
+}
+
+// isHTML reports whether contentType is one that should be parsed for
+// links: that is, HTML or XHTML.
+func isHTML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// report applies the result options to res, prints it according to the
+// output settings, and adds it to the results.
+func (c *Checker) report(res Result) {
+	if d, ok := c.discoveredLink(res.Link); ok {
+		if res.Kind == "" {
+			res.Kind = d.kind
+		}
+		if res.Depth == 0 {
+			res.Depth = d.depth
+		}
+		if c.WarnProtocolRelative && d.protocolRelative && res.Status == StatusOK {
+			res.Status = StatusWarning
+			res.Message += fmt.Sprintf(", but protocol-relative link resolves to %s", res.Link)
+		}
+	}
+	if c.Strict && res.Status == StatusWarning {
+		res.Status = StatusError
+	}
+	if c.isIgnored(res) {
+		res.Status = StatusSkipped
+		res.Message = "ignored: " + res.Message
+		res.Ignored = true
+	}
+	if c.Progress != nil && !c.Quiet {
+		clearProgress(c.Progress)
+	}
+	w := c.Output
+	if c.ErrorOutput != nil && (res.Status == StatusError || res.Status == StatusWarning) {
+		w = c.ErrorOutput
+	}
+	switch {
+	case res.Ignored:
+	case c.verbosity() > 0:
+		line := res.format(c.useColor(w))
+		if c.verbosity() > 1 {
+			line += fmt.Sprintf(" — depth: %d", res.Depth)
+		}
+		if res.FromCache {
+			line += " — from cache"
+		}
+		fmt.Fprintln(w, line)
+	case c.ReportOK && res.Status == StatusOK:
+		fmt.Fprintln(w, res.format(c.useColor(w)))
+	case c.Quiet:
+	case res.Status == StatusError, res.Status == StatusWarning && !c.ErrorsOnly:
+		fmt.Fprintln(w, res.format(c.useColor(w)))
+	}
+	if c.OnResult != nil {
+		c.OnResult(res)
+	}
+	c.appendResult(res)
+	if c.FailFast && res.Status == StatusError {
+		c.stop()
+	}
+}
+
+// isIgnored reports whether res is an error or warning for a link matching
+// any of IgnoreResults.
+func (c *Checker) isIgnored(res Result) bool {
+	if res.Status != StatusError && res.Status != StatusWarning {
+		return false
+	}
+	for _, re := range c.IgnoreResults {
+		if re.MatchString(res.Link) {
+			return true
+		}
+	}
+	return false
+}
+
+// stop cancels the check in progress, and any later ones, after the first
+// error in FailFast mode.
+func (c *Checker) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failed = true
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// startProgress writes a progress line to Progress every second until the
+// returned stop function is called.
+func (c *Checker) startProgress() (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				clearProgress(c.Progress)
+				return
+			case <-ticker.C:
+				c.mu.Lock()
+				checked, queued := len(c.results), len(c.visited)+len(c.pending)
+				c.mu.Unlock()
+				fmt.Fprintf(c.Progress, "\rchecked %d / %d queued", checked, queued)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func clearProgress(w io.Writer) {
+	fmt.Fprint(w, "\r\033[K")
+}
+
+// useColor reports whether results written to w should be colorized. In auto
+// mode, this is the case only when w is a terminal, and the NO_COLOR
+// environment variable is not set.
+func (c *Checker) useColor(w io.Writer) bool {
+	switch c.Color {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
+// Results returns the results recorded so far, in the order they were
+// recorded. Each link is checked only once, but its Referrers lists every
+// page found linking to it.
+func (c *Checker) Results() []Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.resultsLocked()
+}
+
+// All returns an iterator over the results, in the order they were recorded,
+// without copying them all first. It may be used during a crawl, in which
+// case it yields the results recorded so far, plus any recorded while the
+// iteration is in progress. A single iterator must not be used from more than
+// one goroutine at once.
+func (c *Checker) All() iter.Seq[Result] {
+	return func(yield func(Result) bool) {
+		for i := 0; ; i++ {
+			c.mu.Lock()
+			if i >= len(c.results) {
+				c.mu.Unlock()
+				return
+			}
+			res := c.resultLocked(i)
+			c.mu.Unlock()
+			if !yield(res) {
+				return
+			}
+		}
+	}
+}
+
+// SortedResults returns the results recorded so far, sorted by status (errors,
+// then warnings, then skipped links, then OK), and then by link, so that the
+// order is the same from one run to the next.
+func (c *Checker) SortedResults() []Result {
+	results := c.Results()
+	slices.SortStableFunc(results, func(a, b Result) int {
+		if n := cmp.Compare(a.Status.rank(), b.Status.rank()); n != 0 {
+			return n
+		}
+		return strings.Compare(a.Link, b.Link)
+	})
+	return results
+}
+
+// Visited returns every URL visited so far, in sorted order, including those
+// skipped without a request and the start URLs with and without a trailing
+// slash.
+func (c *Checker) Visited() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.visitedLocked()
+}
+
+func (c *Checker) visitedLocked() []string {
+	visited := make([]string, 0, len(c.visited))
+	for link := range c.visited {
+		visited = append(visited, link)
+	}
+	sort.Strings(visited)
+	return visited
+}
+
+func (c *Checker) resultsLocked() []Result {
+	results := make([]Result, len(c.results))
+	for i := range c.results {
+		results[i] = c.resultLocked(i)
+	}
+	return results
+}
+
+// resultLocked returns the ith result, with its Referrers filled in.
+func (c *Checker) resultLocked(i int) Result {
+	res := c.results[i]
+	if refs, ok := c.referrers[res.Link]; ok {
+		res.Referrers = slices.Clone(refs)
+	} else {
+		res.Referrers = []string{res.Referrer}
+	}
+	return res
+}
+
+// Stats returns the statistics for the checks made so far. It's safe to call
+// while a check is in progress.
+func (c *Checker) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := Stats{
+		Links:           len(c.results),
+		Requests:        c.requests,
+		BytesDownloaded: c.bytes,
+		ByteCapReached:  c.byteCapReached,
+	}
+	if c.requests > 0 {
+		s.AverageLatency = c.latency / time.Duration(c.requests)
+	}
+	switch {
+	case c.started.IsZero():
+	case c.finished.IsZero():
+		s.Elapsed = time.Since(c.started)
+	default:
+		s.Elapsed = c.finished.Sub(c.started)
+	}
+	for _, res := range c.results {
+		switch res.Status {
+		case StatusOK:
+			s.OK++
+		case StatusWarning:
+			s.Warnings++
+		case StatusError:
+			s.Errors++
+		case StatusSkipped:
+			s.Skipped++
+			if res.Message == externalSkipMessage {
+				s.ExternalSkipped++
+			}
+		}
+		if res.Status != StatusError && res.Status != StatusWarning {
+			continue
+		}
+		switch {
+		case res.StatusCode != 0 && res.StatusCode != http.StatusOK:
+			if s.StatusCodeCounts == nil {
+				s.StatusCodeCounts = map[int]int{}
+			}
+			s.StatusCodeCounts[res.StatusCode]++
+		case res.ErrorKind != "":
+			if s.ErrorKindCounts == nil {
+				s.ErrorKindCounts = map[ErrorKind]int{}
+			}
+			s.ErrorKindCounts[res.ErrorKind]++
+		}
+	}
+	return s
+}
+
+// Summary returns the totals for the results recorded so far, as shown at the
+// end of a run.
+func (c *Checker) Summary() Summary {
+	st := c.Stats()
+	return Summary{
+		Total:            st.Links,
+		OK:               st.OK,
+		Errors:           st.Errors,
+		Warnings:         st.Warnings,
+		Skipped:          st.Skipped,
+		ExternalSkipped:  st.ExternalSkipped,
+		StatusCodeCounts: st.StatusCodeCounts,
+		ErrorKindCounts:  st.ErrorKindCounts,
+		Elapsed:          st.Elapsed,
+		BytesDownloaded:  st.BytesDownloaded,
+		ByteCapReached:   st.ByteCapReached,
+	}
+}
+
+// log sends a diagnostic event to Logger, if set. Otherwise, events are
+// written to Output according to the verbosity: info-level events at level 1,
+// debug events (such as each request sent and its timing) at level 2, and
+// trace events (such as response headers) at level 3.
+func (c *Checker) log(level slog.Level, msg string, args ...any) {
+	if c.Logger != nil {
+		c.Logger.Log(context.Background(), level, msg, args...)
+		return
+	}
+	switch {
+	case level >= slog.LevelInfo && c.verbosity() >= 1:
+		fmt.Fprintf(c.Output, "[INFO] %s\n", msg)
+	case level >= slog.LevelDebug && level < slog.LevelInfo && c.verbosity() >= 2:
+		fmt.Fprintf(c.Output, "[DEBUG] %s%s\n", msg, formatArgs(args))
+	case level < slog.LevelDebug && c.verbosity() >= 3:
+		fmt.Fprintf(c.Output, "[TRACE] %s%s\n", msg, formatArgs(args))
+	}
+}
+
+// verbosity returns the effective verbosity level. Setting Verbose is
+// equivalent to a Verbosity of 1.
+func (c *Checker) verbosity() int {
+	if c.Verbose && c.Verbosity < 1 {
+		return 1
+	}
+	return c.Verbosity
+}
+
+// formatArgs formats the key-value pairs args for plain-text log output.
+func formatArgs(args []any) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+	}
+	return b.String()
+}
+
+// markVisited reports whether link was newly marked as visited.
+func (c *Checker) markVisited(link string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.visited[link] {
+		return false
+	}
+	c.visited[link] = true
+	return true
+}
+
+// addReferrer records that referrer links to link.
+func (c *Checker) addReferrer(link, referrer string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if canonical, ok := c.aliases[link]; ok {
+		link = canonical
+	}
+	if !slices.Contains(c.referrers[link], referrer) {
+		c.referrers[link] = append(c.referrers[link], referrer)
+	}
+}
+
+// discovery records how a link was first found: the kind of element it was
+// found in, its depth, which is the number of links followed from the start
+// page to reach it, and whether it was protocol-relative.
+type discovery struct {
+	kind             string
+	depth            int
+	protocolRelative bool
+}
+
+// addDiscovery records d as how link was found, unless it's already been
+// found.
+func (c *Checker) addDiscovery(link string, d discovery) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.discovered[link]; !ok {
+		c.discovered[link] = d
+	}
+}
+
+// discoveredLink returns how link was first found, if known.
+func (c *Checker) discoveredLink(link string) (discovery, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.discovered[link]
+	return d, ok
+}
+
+// discoveryOf returns how link was first found, or the zero discovery (depth
+// 0) for a start page.
+func (c *Checker) discoveryOf(link string) discovery {
+	d, _ := c.discoveredLink(link)
+	return d
+}
+
+// addAlias records that link refers to the same page as canonical.
+func (c *Checker) addAlias(link, canonical string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.aliases[link] = canonical
+}
+
+func (c *Checker) unvisit(link string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.visited, link)
+}
+
+func (c *Checker) appendResult(res Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = append(c.results, res)
+}
+
+func (c *Checker) recordRequest(latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	c.latency += latency
+}
+
+// recordBytes adds n to the number of bytes downloaded. Once the total
+// reaches MaxTotalBytes, if set, no more links are checked, though requests
+// already sent are allowed to finish.
+func (c *Checker) recordBytes(n int64) {
+	c.mu.Lock()
+	c.bytes += n
+	total := c.bytes
+	reached := c.MaxTotalBytes > 0 && total >= c.MaxTotalBytes && !c.byteCapReached
+	if reached {
+		c.byteCapReached = true
+	}
+	c.mu.Unlock()
+	if reached {
+		c.log(slog.LevelInfo, fmt.Sprintf("downloaded %d bytes, reaching the limit of %d: no more links will be checked", total, c.MaxTotalBytes), "bytes", total)
+	}
+}
+
+// overByteCap reports whether MaxTotalBytes has been reached.
+func (c *Checker) overByteCap() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.byteCapReached
+}
+
+// Reset clears the results, the pages visited, and the statistics of previous
+// checks, so that the checker can be reused to check a site afresh, keeping
+// its configuration. Hosts are no longer treated as unreachable, and if Login
+// is set, the checker logs in again. The Limiter keeps its current rate, so
+// that a server which asked weaver to slow down isn't immediately hit at full
+// speed again; to start again at the maximum rate, use a new limiter. Reset
+// must not be called during a check.
+func (c *Checker) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.BaseURL = nil
+	c.results = nil
+	c.visited = map[string]bool{}
+	c.referrers = map[string][]string{}
+	c.discovered = map[string]discovery{}
+	c.aliases = map[string]string{}
+	c.completed = map[string]bool{}
+	c.certChecked = map[string]bool{}
+	c.queryVariants = map[string]int{}
+	c.pending = nil
+	c.pathPrefix = ""
+	c.client = nil
+	c.hostFailures = map[string]int{}
+	c.circuits = map[string]time.Time{}
+	c.failed = false
+	c.loggedIn = false
+	c.requests = 0
+	c.bytes = 0
+	c.byteCapReached = false
+	c.latency = 0
+	c.started = time.Time{}
+	c.finished = time.Time{}
+	c.lastRequest = time.Time{}
+}
+
+// SaveState writes the progress of the crawl so far (visited pages, results,
+// and links not yet checked) to w as JSON, so that it can be resumed later
+// using LoadState.
+func (c *Checker) SaveState(w io.Writer) error {
+	c.mu.Lock()
+	st := state{
+		Aliases:   maps.Clone(c.aliases),
+		Completed: make([]string, 0, len(c.completed)),
+		Results:   c.resultsLocked(),
+		Pending:   c.pending,
+	}
+	if c.BaseURL != nil {
+		st.Base = c.BaseURL.String()
+	}
+	st.Visited = c.visitedLocked()
+	for site := range c.completed {
+		st.Completed = append(st.Completed, site)
+	}
+	c.mu.Unlock()
+	sort.Strings(st.Completed)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(st)
+}
+
+// LoadState restores a crawl saved by SaveState, replacing any existing
+// progress. A subsequent Check of the same site resumes the crawl, skipping
+// pages already visited, and sites already completed are not checked again.
+func (c *Checker) LoadState(r io.Reader) error {
+	var st state
+	if err := json.NewDecoder(r).Decode(&st); err != nil {
+		return fmt.Errorf("loading state: %w", err)
+	}
+	var base *url.URL
+	if st.Base != "" {
+		var err error
+		base, err = url.Parse(st.Base)
+		if err != nil {
+			return fmt.Errorf("loading state: %w", err)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.BaseURL = base
+	c.visited = make(map[string]bool, len(st.Visited))
+	for _, link := range st.Visited {
+		c.visited[link] = true
+	}
+	c.completed = make(map[string]bool, len(st.Completed))
+	for _, site := range st.Completed {
+		c.completed[site] = true
+	}
+	c.referrers = map[string][]string{}
+	c.discovered = map[string]discovery{}
+	for i, res := range st.Results {
+		if _, ok := c.referrers[res.Link]; !ok {
+			c.referrers[res.Link] = res.Referrers
+		}
+		st.Results[i].Referrers = nil
+	}
+	c.aliases = st.Aliases
+	if c.aliases == nil {
+		c.aliases = map[string]string{}
+	}
+	c.results = st.Results
+	c.pending = st.Pending
+	return nil
+}
+
+type state struct {
+	Base      string            `json:"base,omitempty"`
+	Aliases   map[string]string `json:"aliases,omitempty"`
+	Completed []string          `json:"completed"`
+	Visited   []string          `json:"visited"`
+	Results   []Result          `json:"results"`
+	Pending   []crawlItem       `json:"pending"`
+}
+
+// Stats holds statistics about a check: the number of links checked, with
+// their totals by status, and the number of requests sent, bytes downloaded,
+// and average time for a response to arrive. ExternalSkipped counts the
+// skipped links to other hosts which weren't checked because CheckExternal is
+// false. Elapsed is the time taken by the check so far.
+type Stats struct {
+	Links            int
+	Requests         int
+	OK               int
+	Warnings         int
+	Errors           int
+	Skipped          int
+	ExternalSkipped  int
+	StatusCodeCounts map[int]int
+	ErrorKindCounts  map[ErrorKind]int
+	BytesDownloaded  int64
+	ByteCapReached   bool
+	AverageLatency   time.Duration
+	Elapsed          time.Duration
+}
+
+// Summary is the machine-readable form of the summary line printed at the end
+// of a run. In JSON, Elapsed is encoded as a number of nanoseconds.
+// StatusCodeCounts counts the errors and warnings by the status code of the
+// response (except for 200 OK, such as slow responses), and ErrorKindCounts
+// counts those which got no response by their ErrorKind. ExternalSkipped
+// counts the skipped links to other hosts, when CheckExternal is false.
+// ByteCapReached is true if the check was stopped early by MaxTotalBytes.
+type Summary struct {
+	Total            int               `json:"total"`
+	OK               int               `json:"ok"`
+	Errors           int               `json:"errors"`
+	Warnings         int               `json:"warnings"`
+	Skipped          int               `json:"skipped"`
+	ExternalSkipped  int               `json:"external_skipped,omitempty"`
+	StatusCodeCounts map[int]int       `json:"status_codes,omitempty"`
+	ErrorKindCounts  map[ErrorKind]int `json:"error_kinds,omitempty"`
+	Elapsed          time.Duration     `json:"elapsed"`
+	BytesDownloaded  int64             `json:"bytes_downloaded"`
+	ByteCapReached   bool              `json:"byte_cap_reached,omitempty"`
+}
+
+func (s Summary) String() string {
+	skipped := ""
+	if s.Skipped > 0 {
+		skipped = fmt.Sprintf(", %d skipped", s.Skipped)
+	}
+	return fmt.Sprintf("Links: %d (%d OK, %d errors, %d warnings%s) [%s]",
+		s.Total, s.OK, s.Errors, s.Warnings, skipped,
+		s.Elapsed.Round(100*time.Millisecond),
+	)
+}
+
+// Breakdown describes the errors and warnings by status code, and then by
+// kind of failure for those which got no response, such as "404: 12, 500: 3,
+// timeout: 2". It returns the empty string if there are none to describe.
+func (s Summary) Breakdown() string {
+	var parts []string
+	for _, code := range slices.Sorted(maps.Keys(s.StatusCodeCounts)) {
+		parts = append(parts, fmt.Sprintf("%d: %d", code, s.StatusCodeCounts[code]))
 	}
-	if res.Status == StatusError || res.Status == StatusWarning || c.Verbose {
-		fmt.Fprintln(c.Output, res)
+	for _, kind := range slices.Sorted(maps.Keys(s.ErrorKindCounts)) {
+		parts = append(parts, fmt.Sprintf("%s: %d", kind, s.ErrorKindCounts[kind]))
 	}
-	c.results = append(c.results, res)
+	return strings.Join(parts, ", ")
 }
 
-func (c *Checker) Results() []Result {
-	return c.results
+// countingBody is a response body which adds the number of bytes read from
+// it to the checker's total.
+type countingBody struct {
+	io.ReadCloser
+	c *Checker
+}
+
+func (cb countingBody) Read(p []byte) (int, error) {
+	n, err := cb.ReadCloser.Read(p)
+	cb.c.recordBytes(int64(n))
+	return n, err
 }
 
 type Result struct {
-	Link     string
-	Status   Status
-	Message  string
-	Referrer string
+	Link        string    `json:"link"`
+	Status      Status    `json:"status"`
+	Message     string    `json:"message"`
+	Referrer    string    `json:"referrer"`
+	Referrers   []string  `json:"referrers,omitempty"`
+	Method      string    `json:"method,omitempty"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	ErrorKind   ErrorKind `json:"error_kind,omitempty"`
+	Redirects   int       `json:"redirects,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Kind        string    `json:"kind,omitempty"`
+	Depth       int       `json:"depth,omitempty"`
+	FromCache   bool      `json:"from_cache,omitempty"`
+	Ignored     bool      `json:"ignored,omitempty"`
 }
 
+// ErrorKind classifies the failure of a request which got no response.
+type ErrorKind string
+
+const (
+	ErrorKindDNS     ErrorKind = "dns"
+	ErrorKindTimeout ErrorKind = "timeout"
+	ErrorKindRefused ErrorKind = "refused"
+	ErrorKindTLS     ErrorKind = "tls"
+	ErrorKindOther   ErrorKind = "other"
+)
+
 func (r Result) String() string {
+	return r.format(!color.NoColor)
+}
+
+func (r Result) format(colorize bool) string {
+	link := r.Link
+	if r.Method != "" && r.Method != http.MethodGet {
+		link = r.Method + " " + link
+	}
 	return fmt.Sprintf("[%s] %s (%s) — referrer: %s",
-		r.Status,
-		r.Link,
+		r.Status.paint(colorize),
+		link,
 		r.Message,
 		r.Referrer,
 	)
@@ -171,17 +2612,79 @@ func (r Result) String() string {
 type Status string
 
 func (s Status) String() string {
-	msg := string(s)
+	return s.paint(!color.NoColor)
+}
+
+// rank orders statuses from most to least severe.
+func (s Status) rank() int {
+	switch s {
+	case StatusError:
+		return 0
+	case StatusWarning:
+		return 1
+	case StatusSkipped:
+		return 2
+	case StatusOK:
+		return 3
+	}
+	return 4
+}
+
+func (s Status) paint(colorize bool) string {
+	var col *color.Color
 	switch s {
 	case StatusOK, StatusSkipped:
-		return color.GreenString(msg)
+		col = color.New(color.FgGreen)
 	case StatusWarning:
-		return color.YellowString(msg)
+		col = color.New(color.FgYellow)
 	case StatusError:
-		return color.RedString(msg)
+		col = color.New(color.FgRed)
 	default:
-		return msg
+		return string(s)
+	}
+	if colorize {
+		col.EnableColor()
+	} else {
+		col.DisableColor()
+	}
+	return col.Sprint(string(s))
+}
+
+type ColorMode string
+
+const (
+	ColorAuto   ColorMode = "auto"
+	ColorAlways ColorMode = "always"
+	ColorNever  ColorMode = "never"
+)
+
+func parseColorMode(s string) (ColorMode, error) {
+	switch mode := ColorMode(s); mode {
+	case ColorAuto, ColorAlways, ColorNever:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid color mode %q (want auto, always, or never)", s)
+}
+
+// Strategy is the order in which a site's pages are crawled.
+type Strategy string
+
+const (
+	// StrategyDFS crawls depth-first, following each link before the
+	// other links on the same page. This is the default.
+	StrategyDFS Strategy = "dfs"
+	// StrategyBFS crawls breadth-first, checking every link on a page
+	// before the links on the pages they lead to, so that each link's
+	// referrer is on the shortest path to it from the start page.
+	StrategyBFS Strategy = "bfs"
+)
+
+func parseStrategy(s string) (Strategy, error) {
+	switch strategy := Strategy(s); strategy {
+	case StrategyDFS, StrategyBFS:
+		return strategy, nil
 	}
+	return "", fmt.Errorf("invalid strategy %q (want dfs or bfs)", s)
 }
 
 const (
@@ -191,94 +2694,737 @@ const (
 	StatusSkipped Status = "SKIP"
 )
 
-var usage = `Usage: weaver [-v] URL
+var usage = `Usage: weaver [-v|-vv|-vvv] [-report-ok] [-q] [-e] [-progress] [-color auto|always|never] [-strategy dfs|bfs] [-state FILE] [-since FILE] [-cache DIR] [-proxy URL] [-accept TYPES] [-accept-language LANGUAGES] [-dry-run] [-f FILE] [-sitemap URL] [-smart] [-html FILE] [-jsonl FILE] [-ok CODES] [-retry CODES] [-retry-on-reset=false] [-no-external] [-external-only] [-external-timeout DURATION] [-external-retries N] [-external-method HEAD|GET] [-fail-fast] [-strict] [-max-bytes N] [-head-first] [-check-opengraph-images] [-ignore-robots-tag] [-delay DURATION] [-same-path-prefix] [-warn-offsite-redirects] [-warn-protocol-relative] [-warn-private-targets] [-resolve HOST:IP,...] [-k] [-max-response-time DURATION] [-base-url URL] [-wait DURATION] [-stderr] [-changed REF] [-format FORMAT] [-ignore REGEXP]... [-login-url URL -login-user USER [-login-user-field NAME] [-login-pass-field NAME]] URL|FILE...
+
+Checks the website at each URL, following all links and reporting any broken links or errors.
+
+Each FILE (or - for standard input) is read as HTML, and the links in it are checked. With -base-url URL, relative links in these files are resolved against URL; otherwise, they're skipped.
+
+In verbose mode (-v), reports all links found. With -vv, also shows how many links deep each was found, and logs each request and how long it took; with -vvv, also logs the response headers.
+
+With -report-ok, also prints the links that are OK, but without the log messages shown in verbose mode.
+
+In quiet mode (-q), prints only the final summary. If both -v and -q are given, -v wins (and likewise -report-ok).
+
+With -e, prints only broken links, not warnings.
+
+With -stderr, prints broken links and warnings to standard error, and everything else to standard output.
+
+With -progress, shows a running count of checked links on standard error (terminals only, and not in quiet mode).
+
+With -state FILE, an interrupted crawl saves its progress to FILE, and a later run with the same FILE resumes where it left off.
+
+With -since FILE, rechecks only the broken links and warnings listed in FILE, a JSON report (or JSON lines file) from an earlier run, without crawling, and lists those that are now fixed.
+
+With -cache DIR, remembers the ETag and Last-Modified headers of responses in DIR, and sends conditional requests on later runs, so that unchanged pages aren't downloaded again.
 
-Checks the website at URL, following all links and reporting any broken links or errors.
+With -f FILE, also checks the URLs listed in FILE, one per line (use - to read from standard input).
 
-In verbose mode (-v), reports all links found.`
+With -sitemap URL, also checks each page listed in the sitemap at URL (without following links).
+
+With -smart, also looks for each site's sitemaps (listed in its robots.txt, or at /sitemap.xml), and checks the pages they list as well as those found by following links.
+
+With -no-external, links to other hosts are skipped instead of checked.
+
+With -external-only, the site is still crawled, but only links to other hosts are reported.
+
+With -external-timeout DURATION, -external-retries N, or -external-method HEAD|GET, links to other hosts are checked with a different timeout, number of retries, or HTTP method from those used for the site itself. Rate limiting by other hosts doesn't slow down the rest of the crawl.
+
+With -strategy bfs, crawls breadth-first instead of depth-first, so that each link's referrer is the page nearest the start URL that links to it.
+
+Links on pages whose X-Robots-Tag header says "nofollow" are not followed, unless -ignore-robots-tag is given.
+
+With -same-path-prefix, only follows links to pages under the directory of each URL (other pages on the same site are checked, but not crawled).
+
+With -warn-offsite-redirects, reports a warning for any link that redirects to a different host.
+
+With -warn-protocol-relative, reports a warning for any protocol-relative link (such as //example.com/), which takes its scheme from the page it's on.
+
+With -warn-private-targets, reports a warning for any link to localhost or a private IP address (such as 10.0.0.1) from a public site, without checking it.
+
+With -resolve HOST:IP,..., connects to the given IP address for each HOST, instead of looking it up in DNS.
+
+With -max-response-time DURATION, reports a warning for any external link which takes longer than DURATION to respond.
+
+With -k, doesn't verify TLS certificates. Use this only for servers you trust, such as a staging server with a self-signed certificate.
+
+With -changed REF, also checks the links in each Markdown or HTML file in the current directory that has changed since the git REF (for example, origin/main).
+
+With -login-url URL, first logs in by posting a form to URL, with the -login-user USER and the password from the WEAVER_LOGIN_PASSWORD environment variable in the fields named by -login-user-field and -login-pass-field (by default, username and password). The session cookies returned are sent with every request.
+
+With -wait DURATION (for example, 30s), first waits up to that long for each URL's server to respond, which is useful when the server has only just been started.
+
+With -head-first, checks every link with a HEAD request first, sending a GET request only for HTML pages on the site, whose links need to be checked too. This saves downloading images, PDFs, and other pages only to throw them away.
+
+With -check-opengraph-images, also checks the social preview images declared in each page's og:image and twitter:image meta tags, using HEAD requests.
+
+With -fail-fast, stops checking as soon as a broken link is found.
+
+With -strict, reports every warning as a broken link instead, so that warnings also cause a non-zero exit status.
+
+With -max-bytes N, stops checking links once N bytes have been downloaded in total, letting any requests already sent finish. Use this to limit the bandwidth used on a metered connection.
+
+With -delay DURATION (for example, 2s), waits that long between requests, instead of adjusting the request rate automatically.
+
+With -ignore REGEXP, doesn't report errors or warnings for links matching REGEXP, or count them towards the exit status (but lists them separately in verbose mode). May be given more than once.
+
+With -ok CODES, treats responses with any of the comma-separated status CODES as OK.
+
+With -retry CODES, retries responses with any of the comma-separated status CODES (by default, 502, 503, and 504) up to twice. Requests whose connection is reset are retried in the same way, unless -retry-on-reset=false is given.
+
+With -format FORMAT, writes a report of the results to standard output in that format once the check is finished, instead of printing each result as it's found. The FORMAT can be text (the default), json, csv, csv-summary (just the totals), junit, sarif, html, markdown, or dot (a GraphViz graph of the links).
+
+With -html FILE, also writes an HTML report of the results to FILE.
+
+With -jsonl FILE, also appends each result to FILE as soon as it's found, as a JSON object on a line of its own.
+
+With -dry-run, shows what would be checked, without making any requests.
+
+With -accept TYPES or -accept-language LANGUAGES, sends the given Accept or Accept-Language header with every request, so that sites which serve different content (and links) depending on these headers are crawled consistently.
+
+Requests are sent via any proxy set in the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables, or via the proxy given by -proxy.
+
+Output is colorized only when writing to a terminal, unless -color says otherwise.
+
+Exits with status 1 if any broken links were found.`
 
 func Main() int {
 	verbose := flag.Bool("v", false, "verbose output")
+	veryVerbose := flag.Bool("vv", false, "verbose output, with requests and timings")
+	traceVerbose := flag.Bool("vvv", false, "verbose output, with requests, timings, and response headers")
+	reportOK := flag.Bool("report-ok", false, "print OK links too, without the other verbose output")
+	quiet := flag.Bool("q", false, "quiet output (summary only)")
+	errorsOnly := flag.Bool("e", false, "only print errors, not warnings")
+	progress := flag.Bool("progress", false, "show crawl progress on stderr")
+	colorFlag := flag.String("color", "auto", "colorize output: auto, always, or never")
+	strategyFlag := flag.String("strategy", "dfs", "crawl order: dfs (depth-first) or bfs (breadth-first)")
+	proxyFlag := flag.String("proxy", "", "send requests via the proxy at `URL`")
+	accept := flag.String("accept", "", "send `types` as the Accept header of each request")
+	acceptLanguage := flag.String("accept-language", "", "send `languages` as the Accept-Language header of each request (for example, en-GB,en)")
+	smart := flag.Bool("smart", false, "also check the pages listed in the site's sitemaps, found via robots.txt")
+	sitemapURL := flag.String("sitemap", "", "check the pages listed in the sitemap at `URL`")
+	urlFile := flag.String("f", "", "read URLs to check from `file`, one per line (- for stdin)")
+	noExternal := flag.Bool("no-external", false, "skip links to other hosts")
+	delay := flag.Duration("delay", 0, "wait a fixed `duration` between requests, instead of adapting the rate")
+	samePathPrefix := flag.Bool("same-path-prefix", false, "only follow links under the start URL's directory")
+	warnPrivateTargets := flag.Bool("warn-private-targets", false, "warn about links to localhost and private IP addresses, without checking them")
+	warnProtocolRelative := flag.Bool("warn-protocol-relative", false, "warn about protocol-relative links, such as //example.com/")
+	warnRedirects := flag.Bool("warn-offsite-redirects", false, "warn about links that redirect to a different host")
+	maxResponseTime := flag.Duration("max-response-time", 0, "warn about external links taking longer than `duration` to respond")
+	strict := flag.Bool("strict", false, "treat warnings as broken links")
+	maxBytes := flag.Int64("max-bytes", 0, "stop checking links after downloading `n` bytes in total")
+	failFast := flag.Bool("fail-fast", false, "stop at the first broken link")
+	externalTimeout := flag.Duration("external-timeout", 0, "give up on external links after `duration`")
+	externalRetries := flag.Int("external-retries", -1, "retry external links at most `n` times (default as for internal links)")
+	externalMethod := flag.String("external-method", "", "check external links with `method` HEAD or GET")
+	externalOnly := flag.Bool("external-only", false, "report only links to other hosts")
+	checkOGImages := flag.Bool("check-opengraph-images", false, "also check the social preview images in og:image and twitter:image meta tags")
+	retryOnReset := flag.Bool("retry-on-reset", true, "retry requests whose connection is reset by the server")
+	ignoreRobotsTag := flag.Bool("ignore-robots-tag", false, "follow links on pages even if their X-Robots-Tag header says nofollow")
+	headFirst := flag.Bool("head-first", false, "check links with HEAD requests, using GET only for pages to be parsed")
+	dryRun := flag.Bool("dry-run", false, "show what would be checked, without making any requests")
+	okCodes := flag.String("ok", "", "treat the comma-separated status `codes` as OK (for example, 401,403)")
+	retryCodes := flag.String("retry", "", "retry responses with the comma-separated status `codes` (default 502,503,504)")
+	htmlFile := flag.String("html", "", "write an HTML report to `file`")
+	jsonlFile := flag.String("jsonl", "", "append each result to `file` as a line of JSON, as soon as it's found")
+	format := flag.String("format", "text", "output `format`: text, json, csv, csv-summary, junit, sarif, html, markdown, or dot")
+	insecure := flag.Bool("k", false, "don't verify TLS certificates (insecure)")
+	resolve := flag.String("resolve", "", "connect to the comma-separated `host:ip` pairs' IP addresses instead of looking up the hosts")
+	baseURL := flag.String("base-url", "", "resolve relative links in local HTML files against `URL`")
+	wait := flag.Duration("wait", 0, "wait up to `duration` for each start URL to respond before checking")
+	changedSince := flag.String("changed", "", "check links in the Markdown and HTML files changed since git `ref`")
+	loginURL := flag.String("login-url", "", "log in by posting a form to `URL` before checking")
+	loginUser := flag.String("login-user", "", "log in as `user` (the password is read from WEAVER_LOGIN_PASSWORD)")
+	loginUserField := flag.String("login-user-field", "username", "the login form's user `field` name")
+	loginPassField := flag.String("login-pass-field", "password", "the login form's password `field` name")
+	var ignore []*regexp.Regexp
+	flag.Func("ignore", "don't report failures for links matching `regexp` (may be repeated)", func(s string) error {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return err
+		}
+		ignore = append(ignore, re)
+		return nil
+	})
+	errorsToStderr := flag.Bool("stderr", false, "print broken links and warnings to stderr instead of stdout")
+	cacheDir := flag.String("cache", "", "cache response validators in `dir`, and send conditional requests")
+	stateFile := flag.String("state", "", "save progress to `file` on interrupt, and resume from it")
+	since := flag.String("since", "", "recheck only the broken links and warnings in the JSON report `file` from an earlier run")
 	flag.Parse()
-	if len(flag.Args()) == 0 {
+	if len(flag.Args()) == 0 && *urlFile == "" && *sitemapURL == "" && *changedSince == "" && *since == "" {
 		fmt.Println(usage)
 		return 0
 	}
-	site := flag.Args()[0]
+	colorMode, err := parseColorMode(*colorFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	strategy, err := parseStrategy(*strategyFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := ValidateFormat(*format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	switch strings.ToUpper(*externalMethod) {
+	case "", http.MethodHead, http.MethodGet:
+	default:
+		fmt.Fprintf(os.Stderr, "invalid external method %q (want HEAD or GET)\n", *externalMethod)
+		return 2
+	}
+	okStatusCodes, err := parseStatusCodes(*okCodes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	retryStatusCodes, err := parseStatusCodes(*retryCodes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	resolveOverrides, err := parseResolveOverrides(*resolve)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var proxy *url.URL
+	if *proxyFlag != "" {
+		proxy, err = url.Parse(*proxyFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	var sites, files []string
+	for _, arg := range flag.Args() {
+		if isLocalFile(arg) {
+			files = append(files, arg)
+		} else {
+			sites = append(sites, arg)
+		}
+	}
+	var base *url.URL
+	if *baseURL != "" {
+		base, err = url.Parse(*baseURL)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if *urlFile != "" {
+		fileSites, err := readURLFile(*urlFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		sites = append(sites, fileSites...)
+	}
+	var previous []Result
+	if *since != "" {
+		previous, err = readResultsFile(*since)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 	c := NewChecker()
 	c.Verbose = *verbose
-	start := time.Now()
+	switch {
+	case *traceVerbose:
+		c.Verbosity = 3
+	case *veryVerbose:
+		c.Verbosity = 2
+	}
+	c.ReportOK = *reportOK
+	c.Quiet = *quiet
+	c.IgnoreResults = ignore
+	if *loginURL != "" {
+		c.Login = &Login{
+			URL:       *loginURL,
+			UserField: *loginUserField,
+			PassField: *loginPassField,
+			User:      *loginUser,
+			Password:  os.Getenv("WEAVER_LOGIN_PASSWORD"),
+		}
+	}
+	if *format != "text" {
+		// the report is written once the check is finished
+		c.Output = io.Discard
+	}
+	if *errorsToStderr {
+		c.ErrorOutput = os.Stderr
+	}
+	c.ErrorsOnly = *errorsOnly
+	c.Color = colorMode
+	c.Strategy = strategy
+	c.SeedFromSitemaps = *smart
+	c.Proxy = proxy
+	c.Accept = *accept
+	c.AcceptLanguage = *acceptLanguage
+	c.DryRun = *dryRun
+	c.HeadFirst = *headFirst
+	c.IgnoreRobotsTag = *ignoreRobotsTag
+	if *checkOGImages {
+		c.LinkSelectors = append(c.LinkSelectors, OpenGraphImageSelector)
+	}
+	c.RetryOnReset = *retryOnReset
+	c.CheckExternal = !*noExternal
+	c.ExternalOnly = *externalOnly
+	if *externalTimeout > 0 || *externalRetries >= 0 || *externalMethod != "" {
+		policy := &ExternalPolicy{
+			Timeout:    *externalTimeout,
+			MaxRetries: *externalRetries,
+			Method:     strings.ToUpper(*externalMethod),
+		}
+		if policy.MaxRetries < 0 {
+			policy.MaxRetries = c.MaxRetries
+		}
+		c.ExternalPolicy = policy
+	}
+	c.FailFast = *failFast
+	c.Strict = *strict
+	c.MaxTotalBytes = *maxBytes
+	c.SamePathPrefix = *samePathPrefix
+	c.WarnCrossDomainRedirect = *warnRedirects
+	c.WarnProtocolRelative = *warnProtocolRelative
+	c.WarnPrivateTargets = *warnPrivateTargets
+	c.ResolveOverrides = resolveOverrides
+	c.InsecureSkipVerify = *insecure
+	if *insecure {
+		fmt.Fprintln(os.Stderr, "warning: TLS certificate verification is disabled (-k)")
+	}
+	c.FixedDelay = *delay
+	c.MaxResponseTime = *maxResponseTime
+	c.OKStatusCodes = okStatusCodes
+	if retryStatusCodes != nil {
+		c.RetryStatusCodes = retryStatusCodes
+	}
+	if *progress && !*quiet && isatty.IsTerminal(os.Stderr.Fd()) {
+		c.Progress = os.Stderr
+	}
+	if *cacheDir != "" {
+		c.Cache, err = OpenCache(*cacheDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if *jsonlFile != "" {
+		f, err := os.OpenFile(*jsonlFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer f.Close()
+		c.OnResult = JSONLWriter(f)
+	}
+	if *stateFile != "" {
+		if err := loadStateFile(c, *stateFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if *wait > 0 && !*dryRun {
+		for _, site := range sites {
+			if err := c.WaitForServer(ctx, site, *wait); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+	}
+	done := make(chan struct{})
 	go func() {
-		c.Check(ctx, site)
-		cancel()
-	}()
-	<-ctx.Done()
-	results := c.Results()
-	ok, errors, warnings := 0, 0, 0
-	if len(results) > 0 {
-		for _, link := range results {
-			switch link.Status {
-			case StatusOK, StatusSkipped:
-				ok++
-			case StatusError:
-				errors++
-			case StatusWarning:
-				warnings++
+		if err := c.CheckAll(ctx, sites...); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		for _, file := range files {
+			if err := checkHTMLFile(ctx, c, file, base); err != nil && !errors.Is(err, context.Canceled) {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+		if *changedSince != "" {
+			if err := checkChangedFiles(ctx, c, *changedSince); err != nil && !errors.Is(err, context.Canceled) {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+		if *sitemapURL != "" {
+			err := c.CheckSitemap(ctx, *sitemapURL)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+		if *since != "" {
+			err := c.Recheck(ctx, previous)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				fmt.Fprintln(os.Stderr, err)
 			}
 		}
+		close(done)
+	}()
+	interrupted := false
+	select {
+	case <-done:
+	case <-ctx.Done():
+		interrupted = true
+		// in-flight requests are cancelled too, so the crawl should stop
+		// almost at once, but don't keep the user waiting if it doesn't
+		select {
+		case <-done:
+		case <-time.After(shutdownGracePeriod):
+		}
+	}
+	if *dryRun {
+		return 0
+	}
+	if *stateFile != "" {
+		if err := saveStateFile(c, *stateFile, interrupted, done); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	if c.Cache != nil {
+		if err := c.Cache.Save(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	if *htmlFile != "" {
+		if err := writeHTMLFile(c, *htmlFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	summary := c.Summary()
+	if *format != "text" {
+		if err := c.WriteReport(os.Stdout, *format); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	} else {
+		if c.verbosity() > 0 {
+			printIgnored(c)
+		}
+		if *since != "" {
+			printFixed(c)
+		}
+		label := ""
+		switch {
+		case interrupted:
+			label = " (interrupted)"
+		case summary.ByteCapReached:
+			label = " (stopped at -max-bytes limit)"
+		}
+		fmt.Printf("\n%s%s\n", summary, label)
+		if summary.ExternalSkipped > 0 {
+			fmt.Printf("External links skipped: %d\n", summary.ExternalSkipped)
+		}
+		if breakdown := summary.Breakdown(); breakdown != "" {
+			fmt.Printf("By status: %s\n", breakdown)
+		}
+	}
+	if summary.Errors > 0 {
+		return 1
 	}
-	fmt.Printf("\nLinks: %d (%d OK, %d errors, %d warnings) [%s]\n",
-		len(results), ok, errors, warnings,
-		time.Since(start).Round(100*time.Millisecond),
-	)
 	return 0
 }
 
+// printIgnored lists any ignored results under their own heading.
+func printIgnored(c *Checker) {
+	header := false
+	for res := range c.All() {
+		if !res.Ignored {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(c.Output, "\nIgnored:")
+			header = true
+		}
+		fmt.Fprintln(c.Output, res.format(c.useColor(c.Output)))
+	}
+}
+
+// printFixed lists the links found to be OK when rechecking an earlier run's
+// failures, under their own heading.
+func printFixed(c *Checker) {
+	header := false
+	for res := range c.All() {
+		if res.Status != StatusOK {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(c.Output, "\nFixed:")
+			header = true
+		}
+		fmt.Fprintln(c.Output, res.format(c.useColor(c.Output)))
+	}
+}
+
+// parseStatusCodes parses a comma-separated list of HTTP status codes.
+func parseStatusCodes(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var codes []int
+	for _, field := range strings.Split(s, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || code < 100 || code > 999 {
+			return nil, fmt.Errorf("invalid status code %q", field)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// parseResolveOverrides parses a comma-separated list of host:ip pairs, such
+// as "www.example.com:203.0.113.1".
+func parseResolveOverrides(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	overrides := map[string]string{}
+	for _, field := range strings.Split(s, ",") {
+		host, ip, ok := strings.Cut(strings.TrimSpace(field), ":")
+		ip = strings.Trim(ip, "[]")
+		if !ok || host == "" || net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("invalid -resolve entry %q (want host:ip)", field)
+		}
+		overrides[host] = ip
+	}
+	return overrides, nil
+}
+
+func writeHTMLFile(c *Checker, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := c.WriteHTML(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// isLocalFile reports whether the command-line argument arg names a local
+// file (or "-", for standard input) rather than a URL.
+func isLocalFile(arg string) bool {
+	if arg == "-" {
+		return true
+	}
+	if strings.Contains(arg, "://") {
+		return false
+	}
+	info, err := os.Stat(arg)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// checkChangedFiles checks the links in the Markdown and HTML files in the
+// current directory changed since the git ref.
+func checkChangedFiles(ctx context.Context, c *Checker, ref string) error {
+	files, err := ChangedFiles(ctx, ".", ref)
+	if err != nil {
+		return err
+	}
+	if c.DryRun {
+		fmt.Fprintf(c.Output, "[DRY RUN] %d files changed since %s\n", len(files), ref)
+	}
+	return c.CheckFileList(ctx, os.DirFS("."), files)
+}
+
+// checkHTMLFile checks the links in the local HTML file at path, or standard
+// input if path is "-".
+func checkHTMLFile(ctx context.Context, c *Checker, path string, base *url.URL) error {
+	if path == "-" {
+		return c.CheckHTML(ctx, os.Stdin, "stdin", base)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.CheckHTML(ctx, f, path, base)
+}
+
+func readURLFile(path string) ([]string, error) {
+	if path == "-" {
+		return ReadURLs(os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadURLs(f)
+}
+
+// ReadURLs reads a list of URLs from r, one per line, ignoring blank lines and
+// comments beginning with #. The URLs are not validated: any invalid URL will
+// be reported as a broken link when checked.
+func ReadURLs(r io.Reader) ([]string, error) {
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+func readResultsFile(path string) ([]Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	results, err := ReadResults(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading results from %s: %w", path, err)
+	}
+	return results, nil
+}
+
+func loadStateFile(c *Checker, path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.LoadState(f)
+}
+
+// saveStateFile saves the state of an interrupted crawl to path, once the
+// crawl has stopped, so that it can be resumed. If the crawl completed, any
+// existing state file is removed.
+func saveStateFile(c *Checker, path string, interrupted bool, done <-chan struct{}) error {
+	if !interrupted {
+		err := os.Remove(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	<-done
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := c.SaveState(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// AdaptiveRateLimiter is safe for concurrent use, though its configuration
+// fields should not be changed while it's in use. To change MaxRate, use
+// SetMaxRate, which also applies it to the current rate.
 type AdaptiveRateLimiter struct {
+	MaxRate          rate.Limit
+	MinRate          rate.Limit
+	GrowthFactor     float64
+	CooldownPeriod   time.Duration
+	Now              func() time.Time
+	mu               sync.Mutex
 	limiter          *rate.Limiter
 	limitLastUpdated time.Time
 }
 
+// NewAdaptiveRateLimiter returns a limiter starting at the default maximum
+// rate of 5 requests per second. After each CooldownPeriod without a
+// reduction, GraduallyIncreaseRateLimit multiplies the rate by GrowthFactor,
+// up to MaxRate. ReduceLimit never reduces the rate below MinRate. The
+// cooldown is timed using Now, which tests can replace with a fake clock.
 func NewAdaptiveRateLimiter() *AdaptiveRateLimiter {
 	return &AdaptiveRateLimiter{
+		MaxRate:          maxRate,
+		MinRate:          minRate,
+		GrowthFactor:     defaultGrowthFactor,
+		CooldownPeriod:   defaultCooldownPeriod,
+		Now:              time.Now,
 		limiter:          rate.NewLimiter(maxRate, 1),
 		limitLastUpdated: time.Now(),
 	}
 }
 
-func (a *AdaptiveRateLimiter) Wait(ctx context.Context) {
-	a.limiter.Wait(ctx)
+// now returns the current time according to Now, or the real time if Now
+// isn't set.
+func (a *AdaptiveRateLimiter) now() time.Time {
+	if a.Now == nil {
+		return time.Now()
+	}
+	return a.Now()
+}
+
+// Wait blocks until the limiter permits another request. It doesn't hold the
+// lock while waiting, so the limit can be changed meanwhile. It returns an
+// error if ctx is cancelled first, or if its deadline would pass before then,
+// in which case no request should be sent.
+func (a *AdaptiveRateLimiter) Wait(ctx context.Context) error {
+	return a.limiter.Wait(ctx)
 }
 
 func (a *AdaptiveRateLimiter) GraduallyIncreaseRateLimit() (increased bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	curLimit := a.limiter.Limit()
-	if curLimit >= maxRate {
+	if curLimit >= a.MaxRate {
 		return false
 	}
-	if time.Since(a.limitLastUpdated) <= 10*time.Second {
+	if a.now().Sub(a.limitLastUpdated) <= a.CooldownPeriod {
 		return false
 	}
-	curLimit *= 1.5
-	if curLimit > maxRate {
-		curLimit = maxRate
+	curLimit *= rate.Limit(a.GrowthFactor)
+	if curLimit > a.MaxRate {
+		curLimit = a.MaxRate
 	}
 	a.limiter.SetLimit(curLimit)
-	a.limitLastUpdated = time.Now()
+	a.limitLastUpdated = a.now()
 	return true
 }
 
-func (a *AdaptiveRateLimiter) ReduceLimit() {
+// ReduceLimit halves the rate, but not below MinRate. It reports whether the
+// rate is now at that floor.
+func (a *AdaptiveRateLimiter) ReduceLimit() (atFloor bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	curLimit := a.limiter.Limit() / 2
+	if curLimit <= a.MinRate {
+		curLimit = a.MinRate
+		atFloor = true
+	}
+	a.limiter.SetLimit(curLimit)
+	a.limitLastUpdated = a.now()
+	return atFloor
+}
+
+// SetMaxRate sets MaxRate to r, and applies it to the current rate: a rate
+// above r is lowered to it, and a rate still at the previous maximum is
+// raised to it, so that the new maximum takes effect straight away.
+func (a *AdaptiveRateLimiter) SetMaxRate(r rate.Limit) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	curLimit := a.limiter.Limit()
-	a.limiter.SetLimit(curLimit / 2)
-	a.limitLastUpdated = time.Now()
+	if curLimit > r || curLimit == a.MaxRate {
+		a.limiter.SetLimit(r)
+	}
+	a.MaxRate = r
 }
 
-func (a AdaptiveRateLimiter) Limit() rate.Limit {
+func (a *AdaptiveRateLimiter) Limit() rate.Limit {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	return a.limiter.Limit()
 }
 
-func (a AdaptiveRateLimiter) SetLimit(r rate.Limit) {
+func (a *AdaptiveRateLimiter) SetLimit(r rate.Limit) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	a.limiter.SetLimit(r)
 }
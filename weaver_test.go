@@ -1,13 +1,26 @@
 package weaver_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"github.com/bitfield/weaver"
 	"github.com/google/go-cmp/cmp"
@@ -27,52 +40,94 @@ func TestCrawlReturnsExpectedResults(t *testing.T) {
 	c.Check(context.Background(), ts.URL)
 	want := []weaver.Result{
 		{
-			Link:     ts.URL,
-			Status:   weaver.StatusOK,
-			Message:  "200 OK",
-			Referrer: "START",
+			Link:        ts.URL,
+			Status:      weaver.StatusOK,
+			Message:     "200 OK",
+			Referrer:    "START",
+			Referrers:   []string{"START", ts.URL + "/go/sucks.html"},
+			Method:      "GET",
+			StatusCode:  200,
+			ContentType: "text/html",
 		},
 		{
-			Link:     ts.URL + "/go/sucks.html",
-			Status:   weaver.StatusOK,
-			Message:  "200 OK",
-			Referrer: ts.URL,
+			Link:        ts.URL + "/go/sucks.html",
+			Status:      weaver.StatusOK,
+			Message:     "200 OK",
+			Referrer:    ts.URL,
+			Referrers:   []string{ts.URL},
+			Method:      "GET",
+			StatusCode:  200,
+			ContentType: "text/html",
+			Kind:        "a",
+			Depth:       1,
 		},
 		{
-			Link:     ts.URL + "/bogus",
-			Status:   weaver.StatusError,
-			Message:  "404 Not Found",
-			Referrer: ts.URL + "/go/sucks.html",
+			Link:        ts.URL + "/bogus",
+			Status:      weaver.StatusError,
+			Message:     "404 Not Found",
+			Referrer:    ts.URL + "/go/sucks.html",
+			Referrers:   []string{ts.URL + "/go/sucks.html"},
+			Method:      "GET",
+			StatusCode:  404,
+			ContentType: "text/plain",
+			Kind:        "a",
+			Depth:       2,
 		},
 		{
-			Link:     ts.URL + "/go/post.html",
-			Status:   weaver.StatusOK,
-			Message:  "200 OK",
-			Referrer: ts.URL + "/go/sucks.html",
+			Link:        ts.URL + "/go/post.html",
+			Status:      weaver.StatusOK,
+			Message:     "200 OK",
+			Referrer:    ts.URL + "/go/sucks.html",
+			Referrers:   []string{ts.URL + "/go/sucks.html"},
+			Method:      "GET",
+			StatusCode:  200,
+			ContentType: "text/html",
+			Kind:        "a",
+			Depth:       2,
 		},
 		{
-			Link:     ts.URL + "/rust_rules.html",
-			Status:   weaver.StatusError,
-			Message:  "404 Not Found",
-			Referrer: ts.URL,
+			Link:        ts.URL + "/rust_rules.html",
+			Status:      weaver.StatusError,
+			Message:     "404 Not Found",
+			Referrer:    ts.URL,
+			Referrers:   []string{ts.URL},
+			Method:      "GET",
+			StatusCode:  404,
+			ContentType: "text/plain",
+			Kind:        "a",
+			Depth:       1,
 		},
 		{
-			Link:     ts.URL + "/invalid_links.html",
-			Status:   weaver.StatusOK,
-			Message:  "200 OK",
-			Referrer: ts.URL,
+			Link:        ts.URL + "/invalid_links.html",
+			Status:      weaver.StatusOK,
+			Message:     "200 OK",
+			Referrer:    ts.URL,
+			Referrers:   []string{ts.URL},
+			Method:      "GET",
+			StatusCode:  200,
+			ContentType: "text/html",
+			Kind:        "a",
+			Depth:       1,
 		},
 		{
-			Link:     "httq://invalid_scheme.html",
-			Status:   weaver.StatusError,
-			Message:  `Get "httq://invalid_scheme.html": unsupported protocol scheme "httq"`,
-			Referrer: ts.URL + "/invalid_links.html",
+			Link:      "httq://invalid_scheme.html",
+			Status:    weaver.StatusError,
+			Message:   `Get "httq://invalid_scheme.html": unsupported protocol scheme "httq"`,
+			Referrer:  ts.URL + "/invalid_links.html",
+			Referrers: []string{ts.URL + "/invalid_links.html"},
+			Method:    "GET",
+			ErrorKind: weaver.ErrorKindOther,
+			Kind:      "a",
+			Depth:     2,
 		},
 		{
-			Link:     "http:// /",
-			Status:   weaver.StatusError,
-			Message:  `parse "http:// /": invalid character " " in host name`,
-			Referrer: ts.URL + "/invalid_links.html",
+			Link:      "http:// /",
+			Status:    weaver.StatusWarning,
+			Message:   `parse "http:// /": invalid character " " in host name`,
+			Referrer:  ts.URL + "/invalid_links.html",
+			Referrers: []string{ts.URL + "/invalid_links.html"},
+			Kind:      "a",
+			Depth:     2,
 		},
 	}
 	got := c.Results()
@@ -81,6 +136,2611 @@ func TestCrawlReturnsExpectedResults(t *testing.T) {
 	}
 }
 
+func TestStatsReportsCrawlTotals(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewTLSServer(
+		http.FileServerFS(testFS),
+	)
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.HTTPClient = ts.Client()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	stats := c.Stats()
+	if stats.Links != 8 {
+		t.Errorf("want 8 links, got %d", stats.Links)
+	}
+	if stats.Requests != 7 {
+		t.Errorf("want 7 requests, got %d", stats.Requests)
+	}
+	if stats.OK != 4 || stats.Errors != 3 || stats.Warnings != 1 {
+		t.Errorf("want 4 OK, 3 errors, 1 warning, got %+v", stats)
+	}
+	if stats.BytesDownloaded == 0 {
+		t.Error("want non-zero bytes downloaded")
+	}
+	if stats.Elapsed <= 0 {
+		t.Errorf("want positive elapsed time, got %v", stats.Elapsed)
+	}
+}
+
+func TestDiagnosticsGoToLoggerNotOutput(t *testing.T) {
+	t.Parallel()
+	limited := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limited {
+			limited = true
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	output, logs := new(bytes.Buffer), new(bytes.Buffer)
+	c := weaver.NewChecker()
+	c.Verbose = true
+	c.Output = output
+	c.Logger = slog.New(slog.NewJSONHandler(logs, nil))
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	if strings.Contains(output.String(), "[INFO]") {
+		t.Errorf("diagnostics written to output: %q", output.String())
+	}
+	if !strings.Contains(logs.String(), `"msg":"reducing rate limit`) {
+		t.Errorf("rate limit change not logged: %q", logs.String())
+	}
+}
+
+func TestVerbosityControlsDiagnosticOutput(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "hello")
+	}))
+	defer ts.Close()
+	tcs := []struct {
+		verbosity      int
+		debug, headers bool
+	}{
+		{verbosity: 1, debug: false, headers: false},
+		{verbosity: 2, debug: true, headers: false},
+		{verbosity: 3, debug: true, headers: true},
+	}
+	for _, tc := range tcs {
+		output := new(bytes.Buffer)
+		c := weaver.NewChecker()
+		c.Verbosity = tc.verbosity
+		c.Output = output
+		c.Limiter.SetLimit(rate.Inf)
+		c.Check(context.Background(), ts.URL)
+		if !strings.Contains(output.String(), "[OKAY] "+ts.URL) {
+			t.Errorf("verbosity %d: want all results printed, got %q", tc.verbosity, output.String())
+		}
+		if got := strings.Contains(output.String(), "[DEBUG] sending request"); got != tc.debug {
+			t.Errorf("verbosity %d: want requests logged %t, got %q", tc.verbosity, tc.debug, output.String())
+		}
+		if got := strings.Contains(output.String(), "X-Test:[hello]"); got != tc.headers {
+			t.Errorf("verbosity %d: want headers logged %t, got %q", tc.verbosity, tc.headers, output.String())
+		}
+	}
+}
+
+func TestReportOKPrintsOKLinksWithoutDiagnostics(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(testFS))
+	defer ts.Close()
+	output := new(bytes.Buffer)
+	c := weaver.NewChecker()
+	c.ReportOK = true
+	c.Output = output
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	for _, want := range []string{"[OKAY] " + ts.URL + "/go/sucks.html", "[DEAD] " + ts.URL + "/bogus"} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("want %q in output, got %q", want, output.String())
+		}
+	}
+	if strings.Contains(output.String(), "[INFO]") {
+		t.Errorf("want no log messages, got %q", output.String())
+	}
+}
+
+func TestQuietModeSuppressesResultOutput(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewTLSServer(
+		http.FileServerFS(testFS),
+	)
+	defer ts.Close()
+	output := new(bytes.Buffer)
+	c := weaver.NewChecker()
+	c.HTTPClient = ts.Client()
+	c.Output = output
+	c.Quiet = true
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	if output.Len() > 0 {
+		t.Errorf("want no output in quiet mode, got %q", output.String())
+	}
+	if len(c.Results()) != 8 {
+		t.Errorf("want 8 results, got %d", len(c.Results()))
+	}
+}
+
+func TestErrorOutputReceivesErrorsAndWarnings(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewTLSServer(
+		http.FileServerFS(testFS),
+	)
+	defer ts.Close()
+	output, errOutput := new(bytes.Buffer), new(bytes.Buffer)
+	c := weaver.NewChecker()
+	c.HTTPClient = ts.Client()
+	c.Output = output
+	c.ErrorOutput = errOutput
+	c.Verbose = true
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	if strings.Contains(output.String(), "[DEAD]") {
+		t.Errorf("errors written to Output: %q", output.String())
+	}
+	if !strings.Contains(output.String(), "[OKAY]") {
+		t.Errorf("OK results not written to Output: %q", output.String())
+	}
+	if strings.Count(errOutput.String(), "[DEAD]") != 3 || strings.Count(errOutput.String(), "[WARN]") != 1 {
+		t.Errorf("want 3 errors and 1 warning written to ErrorOutput, got %q", errOutput.String())
+	}
+	if strings.Contains(errOutput.String(), "[OKAY]") {
+		t.Errorf("OK results written to ErrorOutput: %q", errOutput.String())
+	}
+}
+
+func TestColorModeControlsEscapeCodesInOutput(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+	tcs := map[weaver.ColorMode]bool{
+		weaver.ColorAuto:   false,
+		weaver.ColorAlways: true,
+		weaver.ColorNever:  false,
+	}
+	for mode, want := range tcs {
+		output := new(bytes.Buffer)
+		c := weaver.NewChecker()
+		c.Output = output
+		c.Color = mode
+		c.Check(context.Background(), ts.URL)
+		got := strings.Contains(output.String(), "\x1b[")
+		if want != got {
+			t.Errorf("%s: want colorized %t, got %t (%q)", mode, want, got, output.String())
+		}
+	}
+}
+
+func TestSavedStateResumesInterruptedCrawl(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	files := http.FileServerFS(testFS)
+	var interrupt atomic.Bool
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if interrupt.Load() && r.URL.Path == "/go/sucks.html" {
+			cancel()
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	newChecker := func() *weaver.Checker {
+		c := weaver.NewChecker()
+		c.HTTPClient = ts.Client()
+		c.Output = io.Discard
+		c.Limiter.SetLimit(rate.Inf)
+		return c
+	}
+	full := newChecker()
+	full.Check(context.Background(), ts.URL)
+	interrupt.Store(true)
+	interrupted := newChecker()
+	interrupted.Check(ctx, ts.URL)
+	if len(interrupted.Results()) >= len(full.Results()) {
+		t.Fatalf("crawl not interrupted: %v", interrupted.Results())
+	}
+	state := new(bytes.Buffer)
+	err := interrupted.SaveState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed := newChecker()
+	err = resumed.LoadState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed.Check(context.Background(), ts.URL)
+	want, got := full.Results(), resumed.Results()
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if resumed.Stats().Requests >= full.Stats().Requests {
+		t.Errorf("resumed crawl repeated requests: %d of %d",
+			resumed.Stats().Requests, full.Stats().Requests)
+	}
+}
+
+func TestProxyIsUsedAndRateLimited(t *testing.T) {
+	t.Parallel()
+	var hosts []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.URL.Host)
+		io.WriteString(w, `<a href="/one">One</a><a href="/two">Two</a>`)
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Proxy = proxyURL
+	c.Limiter.SetLimit(20)
+	c.Check(context.Background(), "http://weaver.invalid/")
+	want := []string{"weaver.invalid", "weaver.invalid", "weaver.invalid"}
+	if !cmp.Equal(want, hosts) {
+		t.Error(cmp.Diff(want, hosts))
+	}
+	if elapsed := c.Stats().Elapsed; elapsed < 90*time.Millisecond {
+		t.Errorf("want rate limit to apply via proxy, but 3 requests took %v", elapsed)
+	}
+}
+
+func TestSoftNotFoundPagesAreRecordedAsWarnings(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/missing" {
+			io.WriteString(w, `<html><head><title>Page Not Found</title></head></html>`)
+			return
+		}
+		io.WriteString(w, `<html><body><a href="/missing">Missing</a></body></html>`)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.SoftNotFoundPatterns = []*regexp.Regexp{regexp.MustCompile(`(?i)page not found`)}
+	c.Check(context.Background(), ts.URL)
+	got := c.Results()
+	if len(got) != 2 {
+		t.Fatalf("unexpected result set %v", got)
+	}
+	if got[0].Status != weaver.StatusOK {
+		t.Errorf("want start page status %q, got %q", weaver.StatusOK, got[0].Status)
+	}
+	if got[1].Status != weaver.StatusWarning {
+		t.Errorf("want soft 404 status %q, got %q", weaver.StatusWarning, got[1].Status)
+	}
+	if !strings.Contains(got[1].Message, "missing page") {
+		t.Errorf("want explanatory message, got %q", got[1].Message)
+	}
+}
+
+func TestMixedContentIsRecordedAsWarning(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<html><body><img src="http://weaver.invalid/logo.png"></body></html>`)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.HTTPClient = ts.Client()
+	c.Output = io.Discard
+	c.CheckMixedContent = true
+	c.Check(context.Background(), ts.URL)
+	want := []weaver.Result{
+		{
+			Link:        ts.URL,
+			Status:      weaver.StatusOK,
+			Message:     "200 OK",
+			Referrer:    "START",
+			Referrers:   []string{"START"},
+			Method:      "GET",
+			StatusCode:  200,
+			ContentType: "text/html",
+		},
+		{
+			Link:      "http://weaver.invalid/logo.png",
+			Status:    weaver.StatusWarning,
+			Message:   "mixed content: plain HTTP URL on HTTPS page",
+			Referrer:  ts.URL,
+			Referrers: []string{ts.URL},
+		},
+	}
+	got := c.Results()
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestCertExpiryIsWarnedOncePerHost(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewTLSServer(
+		http.FileServerFS(testFS),
+	)
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.HTTPClient = ts.Client()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.CertExpiryWindow = 200 * 365 * 24 * time.Hour
+	c.Check(context.Background(), ts.URL)
+	var warnings []weaver.Result
+	for _, res := range c.Results() {
+		if strings.Contains(res.Message, "TLS certificate") {
+			warnings = append(warnings, res)
+		}
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("want 1 expiry warning, got %v", warnings)
+	}
+	if warnings[0].Link != ts.URL {
+		t.Errorf("want expiry noted on start page's result, got %v", warnings[0])
+	}
+	if warnings[0].Status != weaver.StatusWarning {
+		t.Errorf("want status %q, got %q", weaver.StatusWarning, warnings[0].Status)
+	}
+	if got := c.Summary().Total; got != 8 {
+		t.Errorf("want each link counted once (8 in total), got %d", got)
+	}
+}
+
+func TestDryRunMakesNoRequests(t *testing.T) {
+	t.Parallel()
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer ts.Close()
+	output := new(bytes.Buffer)
+	c := weaver.NewChecker()
+	c.Output = output
+	c.DryRun = true
+	c.Check(context.Background(), ts.URL)
+	if requests > 0 {
+		t.Errorf("want no requests, got %d", requests)
+	}
+	if len(c.Results()) > 0 {
+		t.Errorf("want no results, got %v", c.Results())
+	}
+	if !strings.Contains(output.String(), "would check "+ts.URL) {
+		t.Errorf("want description of crawl, got %q", output.String())
+	}
+	if !strings.Contains(output.String(), "first URLs to visit:\n[DRY RUN]   "+ts.URL+"\n") {
+		t.Errorf("want start URL listed as first to visit, got %q", output.String())
+	}
+}
+
+func TestCheckResultsReturnsResultsForThatSite(t *testing.T) {
+	t.Parallel()
+	site1 := httptest.NewServer(http.FileServerFS(testFS))
+	defer site1.Close()
+	site2 := httptest.NewServer(http.NotFoundHandler())
+	defer site2.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	got, err := c.CheckResults(context.Background(), site1.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 8 {
+		t.Errorf("want 8 results, got %d", len(got))
+	}
+	got, err = c.CheckResults(context.Background(), site2.URL)
+	if err == nil {
+		t.Error("want error for unreachable start page, got nil")
+	}
+	if len(got) != 1 || got[0].Link != site2.URL {
+		t.Errorf("want only second site's result, got %v", got)
+	}
+	if len(c.Results()) != 9 {
+		t.Errorf("want 9 results in total, got %d", len(c.Results()))
+	}
+}
+
+func TestResetClearsResultsButKeepsConfiguration(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(testFS))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.ErrorsOnly = true
+	c.Check(context.Background(), ts.URL)
+	c.Reset()
+	if len(c.Results()) != 0 || len(c.Visited()) != 0 {
+		t.Fatalf("want no results or visited pages after Reset, got %v, %v", c.Results(), c.Visited())
+	}
+	if c.Stats().Requests != 0 {
+		t.Errorf("want stats reset, got %d requests", c.Stats().Requests)
+	}
+	if !c.ErrorsOnly || c.Limiter.Limit() != rate.Inf {
+		t.Error("want configuration kept after Reset")
+	}
+	c.Check(context.Background(), ts.URL)
+	if len(c.Results()) != 8 {
+		t.Errorf("want site checked again, got %d results", len(c.Results()))
+	}
+}
+
+func TestRecheckChecksOnlyPreviousFailures(t *testing.T) {
+	t.Parallel()
+	var requested []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		if r.URL.Path == "/still-broken" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	previous := []weaver.Result{
+		{Link: ts.URL, Status: weaver.StatusOK, Referrer: "START"},
+		{Link: ts.URL + "/fixed", Status: weaver.StatusError, Referrer: ts.URL},
+		{Link: ts.URL + "/still-broken", Status: weaver.StatusError, Referrer: ts.URL},
+		{Link: ts.URL + "/slow", Status: weaver.StatusWarning, Referrer: ts.URL},
+		{Link: ts.URL + "/ignored", Status: weaver.StatusSkipped, Referrer: ts.URL, Ignored: true},
+	}
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	if err := c.Recheck(context.Background(), previous); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]weaver.Status{
+		ts.URL + "/fixed":        weaver.StatusOK,
+		ts.URL + "/still-broken": weaver.StatusError,
+		ts.URL + "/slow":         weaver.StatusOK,
+	}
+	got := map[string]weaver.Status{}
+	for _, res := range c.Results() {
+		got[res.Link] = res.Status
+		if res.Referrer != ts.URL {
+			t.Errorf("%s: want referrer %q, got %q", res.Link, ts.URL, res.Referrer)
+		}
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if len(requested) != 3 {
+		t.Errorf("want 3 requests, got %v", requested)
+	}
+}
+
+func TestRecheckMakesNoRequestsInDryRun(t *testing.T) {
+	t.Parallel()
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer ts.Close()
+	previous := []weaver.Result{
+		{Link: ts.URL, Status: weaver.StatusOK, Referrer: "START"},
+		{Link: ts.URL + "/broken", Status: weaver.StatusError, Referrer: ts.URL},
+	}
+	output := new(bytes.Buffer)
+	c := weaver.NewChecker()
+	c.Output = output
+	c.DryRun = true
+	if err := c.Recheck(context.Background(), previous); err != nil {
+		t.Fatal(err)
+	}
+	if requests > 0 {
+		t.Errorf("want no requests, got %d", requests)
+	}
+	if len(c.Results()) > 0 {
+		t.Errorf("want no results, got %v", c.Results())
+	}
+	want := "[DRY RUN] would recheck " + ts.URL + "/broken\n"
+	if output.String() != want {
+		t.Errorf("want %q, got %q", want, output.String())
+	}
+}
+
+func TestCheckAllSharesVisitedPagesBetweenSites(t *testing.T) {
+	t.Parallel()
+	requests := map[string]int{}
+	var mu sync.Mutex
+	shared := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+	}))
+	defer shared.Close()
+	page := `<html><body><a href="` + shared.URL + `/common">Common</a></body></html>`
+	site1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, page)
+	}))
+	defer site1.Close()
+	site2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, page)
+	}))
+	defer site2.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.CheckAll(context.Background(), site1.URL, site2.URL)
+	want := []weaver.Result{
+		{
+			Link:        site1.URL,
+			Status:      weaver.StatusOK,
+			Message:     "200 OK",
+			Referrer:    "START",
+			Referrers:   []string{"START"},
+			Method:      "GET",
+			StatusCode:  200,
+			ContentType: "text/html",
+		},
+		{
+			Link:       shared.URL + "/common",
+			Status:     weaver.StatusOK,
+			Message:    "200 OK",
+			Referrer:   site1.URL,
+			Referrers:  []string{site1.URL, site2.URL},
+			Method:     "GET",
+			StatusCode: 200,
+			Kind:       "a",
+			Depth:      1,
+		},
+		{
+			Link:        site2.URL,
+			Status:      weaver.StatusOK,
+			Message:     "200 OK",
+			Referrer:    "START",
+			Referrers:   []string{"START"},
+			Method:      "GET",
+			StatusCode:  200,
+			ContentType: "text/html",
+		},
+	}
+	got := c.Results()
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if requests["/common"] != 1 {
+		t.Errorf("want shared page requested once, got %d", requests["/common"])
+	}
+}
+
+func TestReadURLsIgnoresBlankLinesAndComments(t *testing.T) {
+	t.Parallel()
+	input := strings.NewReader(`# sites to check
+https://example.com
+
+  https://example.org  
+# https://example.net
+http:// /
+`)
+	want := []string{"https://example.com", "https://example.org", "http:// /"}
+	got, err := weaver.ReadURLs(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestOKStatusCodesAreRecordedAsOK(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.OKStatusCodes = []int{http.StatusUnauthorized, http.StatusForbidden}
+	c.Check(context.Background(), ts.URL)
+	got := c.Results()
+	if len(got) != 1 {
+		t.Fatalf("unexpected result set %v", got)
+	}
+	if got[0].Status != weaver.StatusOK {
+		t.Errorf("want status %q, got %q", weaver.StatusOK, got[0].Status)
+	}
+}
+
+func TestStatusClassifierOverridesDefaultMapping(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.StatusClassifier = func(code int) weaver.Status {
+		if code == http.StatusForbidden {
+			return weaver.StatusWarning
+		}
+		return weaver.DefaultStatusClassifier(code)
+	}
+	c.Check(context.Background(), ts.URL)
+	got := c.Results()
+	if len(got) != 1 {
+		t.Fatalf("unexpected result set %v", got)
+	}
+	if got[0].Status != weaver.StatusWarning {
+		t.Errorf("want status %q, got %q", weaver.StatusWarning, got[0].Status)
+	}
+}
+
+func TestCrawlStopsPromptlyWhenCancelled(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 3 {
+			cancel()
+			// wait for the client to abandon the request
+			<-r.Context().Done()
+			return
+		}
+		for i := range 100 {
+			fmt.Fprintf(w, `<a href="/%d">Page %d</a>`, i, i)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	start := time.Now()
+	c.Check(ctx, ts.URL)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("crawl took %v to stop after cancellation", elapsed)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("want no requests after cancellation, got %d in total", got)
+	}
+	// the third request was cancelled in flight, so it has no result
+	if len(c.Results()) != 2 {
+		t.Errorf("want 2 results, got %d", len(c.Results()))
+	}
+}
+
+func TestCancellingCrawlInterruptsInFlightRequest(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// hang until the client gives up
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	c.Check(ctx, ts.URL)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("in-flight request took %v to stop after cancellation", elapsed)
+	}
+}
+
+func TestOversizedPagesAreTruncatedWithWarning(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<html><body><a href="/early">Early</a>`)
+		io.WriteString(w, strings.Repeat(" ", 1000))
+		io.WriteString(w, `<a href="/late">Late</a></body></html>`)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.MaxBodySize = 500
+	c.Check(context.Background(), ts.URL)
+	got := c.Results()
+	if len(got) != 2 {
+		t.Fatalf("want start page and early link only, got %v", got)
+	}
+	if got[0].Status != weaver.StatusWarning {
+		t.Errorf("want oversized page status %q, got %q", weaver.StatusWarning, got[0].Status)
+	}
+	if got[1].Link != ts.URL+"/early" {
+		t.Errorf("want early link to be checked, got %q", got[1].Link)
+	}
+}
+
+func TestNonHTMLPagesAreNotParsedForLinks(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(fstest.MapFS{
+		"index.html": {
+			Data: []byte(`<html><body><a href="notes.txt">Notes</a></body></html>`),
+		},
+		"notes.txt": {
+			Data: []byte(`Remember to fix <a href="/not-a-link.html">this</a>`),
+		},
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	got := c.Results()
+	if len(got) != 2 {
+		t.Fatalf("want start page and text file only, got %v", got)
+	}
+	if got[1].Link != ts.URL+"/notes.txt" || got[1].Status != weaver.StatusOK {
+		t.Errorf("want text file checked as OK, got %v", got[1])
+	}
+}
+
+func TestExternalLinksAreSkippedWhenCheckExternalIsFalse(t *testing.T) {
+	t.Parallel()
+	externalRequests := 0
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		externalRequests++
+	}))
+	defer external.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<html><body><a href="`+external.URL+`/">External</a></body></html>`)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.CheckExternal = false
+	c.Check(context.Background(), ts.URL)
+	if externalRequests > 0 {
+		t.Errorf("want no requests to external host, got %d", externalRequests)
+	}
+	got := c.Results()
+	if len(got) != 2 {
+		t.Fatalf("unexpected result set %v", got)
+	}
+	if got[1].Status != weaver.StatusSkipped {
+		t.Errorf("want external link status %q, got %q", weaver.StatusSkipped, got[1].Status)
+	}
+	if c.Stats().Skipped != 1 {
+		t.Errorf("want 1 skipped link, got %d", c.Stats().Skipped)
+	}
+}
+
+func TestSummaryCountsSkippedExternalLinksSeparately(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<html><body><a href="https://example.com/">External</a><a href="/a/b/c/d">Trap</a></body></html>`)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.CheckExternal = false
+	c.MaxPathSegments = 2
+	c.Check(context.Background(), ts.URL)
+	s := c.Summary()
+	if s.Skipped != 2 {
+		t.Errorf("want 2 skipped links, got %d", s.Skipped)
+	}
+	if s.ExternalSkipped != 1 {
+		t.Errorf("want 1 skipped external link, got %d", s.ExternalSkipped)
+	}
+}
+
+func TestOnlyExternalLinksAreReportedWhenExternalOnlyIsTrue(t *testing.T) {
+	t.Parallel()
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer external.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, `<html><body><a href="/missing">Internal</a><a href="`+external.URL+`/gone">External</a></body></html>`)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.ExternalOnly = true
+	c.Check(context.Background(), ts.URL)
+	want := map[string]weaver.Status{
+		ts.URL:                 weaver.StatusSkipped,
+		ts.URL + "/missing":    weaver.StatusSkipped,
+		external.URL + "/gone": weaver.StatusError,
+	}
+	got := map[string]weaver.Status{}
+	for _, res := range c.Results() {
+		got[res.Link] = res.Status
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestPagesWithDifferentCanonicalURLAreReportedAsWarnings(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			io.WriteString(w, `<html><head><link rel="canonical" href="/"></head><body><a href="/copy">Copy</a></body></html>`)
+		case "/copy":
+			io.WriteString(w, `<html><head><link rel="canonical" href="/"></head><body></body></html>`)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	got := c.Results()
+	if len(got) != 2 {
+		t.Fatalf("unexpected result set %v", got)
+	}
+	if got[0].Status != weaver.StatusOK {
+		t.Errorf("want status %q for page with matching canonical URL, got %q", weaver.StatusOK, got[0].Status)
+	}
+	if got[1].Status != weaver.StatusWarning {
+		t.Errorf("want status %q for page with different canonical URL, got %q", weaver.StatusWarning, got[1].Status)
+	}
+	if !strings.Contains(got[1].Message, ts.URL+"/copy") || !strings.Contains(got[1].Message, "canonical URL is "+ts.URL+"/") {
+		t.Errorf("want both URLs in message, got %q", got[1].Message)
+	}
+}
+
+func TestOnResultIsCalledForEachResult(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(testFS))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	var got []string
+	c.OnResult = func(res weaver.Result) {
+		if len(c.Results()) != len(got) {
+			t.Errorf("want OnResult called before result %q is recorded", res.Link)
+		}
+		got = append(got, res.Link)
+	}
+	c.Check(context.Background(), ts.URL)
+	var want []string
+	for _, res := range c.Results() {
+		want = append(want, res.Link)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestAcceptHeadersAreSentWithEachRequest(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Language") != "fr" || r.Header.Get("Accept") != "text/html" {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		if r.URL.Path == "/" {
+			io.WriteString(w, `<a href="/fr/">Accueil</a>`)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Accept = "text/html"
+	c.AcceptLanguage = "fr"
+	c.Check(context.Background(), ts.URL)
+	for _, res := range c.Results() {
+		if res.Status != weaver.StatusOK {
+			t.Errorf("%s: want OK, got %s (%s)", res.Link, res.Status, res.Message)
+		}
+	}
+	if len(c.Results()) != 2 {
+		t.Errorf("want 2 results, got %d", len(c.Results()))
+	}
+}
+
+func TestBeforeRequestCanModifyOrSkipRequests(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, `<html><body><a href="/logout">Log out</a><a href="/broken">Broken</a></body></html>`)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.BeforeRequest = func(req *http.Request) error {
+		switch req.URL.Path {
+		case "/logout":
+			return weaver.ErrSkip
+		case "/broken":
+			return errors.New("oh no")
+		}
+		req.Header.Set("X-Token", "secret")
+		return nil
+	}
+	c.Check(context.Background(), ts.URL)
+	want := []weaver.Result{
+		{
+			Link:        ts.URL,
+			Status:      weaver.StatusOK,
+			Message:     "200 OK",
+			Referrer:    "START",
+			Referrers:   []string{"START"},
+			Method:      "GET",
+			StatusCode:  200,
+			ContentType: "text/html",
+		},
+		{
+			Link:      ts.URL + "/logout",
+			Status:    weaver.StatusSkipped,
+			Message:   weaver.ErrSkip.Error(),
+			Referrer:  ts.URL,
+			Referrers: []string{ts.URL},
+			Kind:      "a",
+			Depth:     1,
+		},
+		{
+			Link:      ts.URL + "/broken",
+			Status:    weaver.StatusError,
+			Message:   "oh no",
+			Referrer:  ts.URL,
+			Referrers: []string{ts.URL},
+			ErrorKind: weaver.ErrorKindOther,
+			Kind:      "a",
+			Depth:     1,
+		},
+	}
+	got := c.Results()
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestCrawlerTrapsAreSkipped(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// every page links to a page one level deeper
+		io.WriteString(w, `<html><body><a href="a/">Deeper</a></body></html>`)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.MaxRepeatedSegments = 2
+	c.Check(context.Background(), ts.URL)
+	got := c.Results()
+	if len(got) != 4 {
+		t.Fatalf("want 4 results, got %v", got)
+	}
+	last := got[len(got)-1]
+	want := weaver.Result{
+		Link:      ts.URL + "/a/a/a/",
+		Status:    weaver.StatusSkipped,
+		Message:   "possible crawler trap",
+		Referrer:  ts.URL + "/a/a/",
+		Referrers: []string{ts.URL + "/a/a/"},
+		Kind:      "a",
+		Depth:     3,
+	}
+	if !cmp.Equal(want, last) {
+		t.Error(cmp.Diff(want, last))
+	}
+}
+
+func TestFailFastStopsAtFirstError(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, `<html><body><a href="/one">One</a><a href="/two">Two</a></body></html>`)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.FailFast = true
+	c.CheckAll(context.Background(), ts.URL, ts.URL+"/other")
+	got := c.Results()
+	if len(got) != 2 {
+		t.Fatalf("want 2 results, got %v", got)
+	}
+	if got[1].Link != ts.URL+"/one" || got[1].Status != weaver.StatusError {
+		t.Errorf("want first broken link as last result, got %v", got[1])
+	}
+}
+
+func TestCheckReturnsErrorWhenStartPageIsUnreachable(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	err := c.Check(context.Background(), ts.URL)
+	if err == nil {
+		t.Fatal("want error for unreachable start URL, got nil")
+	}
+	if !strings.Contains(err.Error(), "could not reach start URL "+ts.URL) {
+		t.Errorf("unexpected error message %q", err)
+	}
+}
+
+func TestCheckReturnsNilWhenStartPageIsReachable(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(testFS))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	err := c.Check(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckDefaultsMissingSchemeToHTTPS(t *testing.T) {
+	t.Parallel()
+	for _, site := range []string{"example.com", "//example.com"} {
+		output := new(bytes.Buffer)
+		c := weaver.NewChecker()
+		c.Output = output
+		c.DryRun = true
+		c.Check(context.Background(), site)
+		if !strings.Contains(output.String(), "would check https://example.com") {
+			t.Errorf("%q: want https scheme by default, got %q", site, output.String())
+		}
+	}
+}
+
+func TestCheckFallsBackToHTTPWhenHTTPSIsUnavailable(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(testFS))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	err := c.Check(context.Background(), strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := c.Results()
+	if len(got) == 0 || got[0].Link != ts.URL || got[0].Status != weaver.StatusOK {
+		t.Errorf("want start page checked over http, got %v", got)
+	}
+}
+
+func TestCheckRejectsUnsupportedSchemes(t *testing.T) {
+	t.Parallel()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	err := c.Check(context.Background(), "ftp://x")
+	if err == nil {
+		t.Fatal("want error for ftp URL, got nil")
+	}
+	if !strings.Contains(err.Error(), `unsupported scheme "ftp"`) {
+		t.Errorf("unexpected error message %q", err)
+	}
+}
+
+func TestFixedDelayWaitsBetweenRequests(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var times []time.Time
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		n := len(times)
+		mu.Unlock()
+		if r.URL.Path == "/" {
+			io.WriteString(w, `<html><body><a href="/a">A</a></body></html>`)
+			return
+		}
+		if n == 2 {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.FixedDelay = 50 * time.Millisecond
+	c.Check(context.Background(), ts.URL)
+	if len(times) != 3 {
+		t.Fatalf("want 3 requests, got %d", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		// allow for variation in the time taken for requests to arrive
+		if gap := times[i].Sub(times[i-1]); gap < c.FixedDelay*9/10 {
+			t.Errorf("want at least %s between requests, got %s", c.FixedDelay, gap)
+		}
+	}
+	if c.Limiter.Limit() != rate.Inf {
+		t.Errorf("want rate limit unchanged by 429 response, got %v", c.Limiter.Limit())
+	}
+}
+
+func TestVisitedReturnsEveryURLVisitedInSortedOrder(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(testFS))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	want := []string{
+		ts.URL,
+		ts.URL + "/",
+		ts.URL + "/bogus",
+		ts.URL + "/go/post.html",
+		ts.URL + "/go/sucks.html",
+		ts.URL + "/invalid_links.html",
+		ts.URL + "/rust_rules.html",
+		"httq://invalid_scheme.html",
+	}
+	got := c.Visited()
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestCircuitBreakerShortCircuitsLinksToFailingHost(t *testing.T) {
+	t.Parallel()
+	dead := httptest.NewServer(nil)
+	dead.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := range 5 {
+			fmt.Fprintf(w, `<a href="%s/%d">Dead</a>`, dead.URL, i)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.CircuitBreakerThreshold = 3
+	c.Check(context.Background(), ts.URL)
+	got := c.Results()
+	if len(got) != 6 {
+		t.Fatalf("want 6 results, got %v", got)
+	}
+	for i, res := range got[1:] {
+		if res.Status != weaver.StatusError {
+			t.Errorf("%s: want status %q, got %q", res.Link, weaver.StatusError, res.Status)
+		}
+		open := res.Message == "host unreachable (circuit open)"
+		if open != (i >= 3) {
+			t.Errorf("%s: unexpected message %q", res.Link, res.Message)
+		}
+	}
+	if c.Stats().Requests != 4 {
+		t.Errorf("want 4 requests, got %d", c.Stats().Requests)
+	}
+}
+
+func TestCircuitBreakerIgnoresCertVerifyFailures(t *testing.T) {
+	t.Parallel()
+	tlsServer := httptest.NewTLSServer(nil)
+	defer tlsServer.Close()
+	tlsServer.Config.ErrorLog = log.New(io.Discard, "", 0)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := range 5 {
+			fmt.Fprintf(w, `<a href="%s/%d">Self-signed</a>`, tlsServer.URL, i)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.CircuitBreakerThreshold = 3
+	c.Check(context.Background(), ts.URL)
+	got := c.Results()
+	if len(got) != 6 {
+		t.Fatalf("want 6 results, got %v", got)
+	}
+	for _, res := range got[1:] {
+		if res.Status != weaver.StatusWarning {
+			t.Errorf("%s: want status %q, got %q (%s)", res.Link, weaver.StatusWarning, res.Status, res.Message)
+		}
+	}
+}
+
+func TestInternationalAndIPv6HostsAreComparedCorrectly(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		base, link string
+	}{
+		{base: "http://例え.jp", link: "http://xn--r8jz45g.jp/page"},
+		{base: "http://xn--r8jz45g.jp", link: "http://例え.JP/page"},
+		{base: "http://[::1]:8080", link: "http://[0:0::1]:8080/page"},
+	}
+	for _, tc := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/" {
+				fmt.Fprintf(w, `<a href="%s">Same host</a><a href="http://[::1]:8081/">Other host</a>`, tc.link)
+			}
+		}))
+		c := weaver.NewChecker()
+		c.Output = io.Discard
+		c.Limiter.SetLimit(rate.Inf)
+		c.HTTPClient.Transport = redirectTransport{target: ts.URL}
+		c.CheckExternal = false
+		c.Check(context.Background(), tc.base)
+		ts.Close()
+		got := c.Results()
+		if len(got) != 3 {
+			t.Errorf("%s: unexpected result set %v", tc.base, got)
+			continue
+		}
+		if got[1].Status != weaver.StatusOK {
+			t.Errorf("%s: want %s treated as same host, got %v", tc.base, tc.link, got[1])
+		}
+		if got[2].Status != weaver.StatusSkipped {
+			t.Errorf("%s: want other host skipped, got %v", tc.base, got[2])
+		}
+	}
+}
+
+// redirectTransport sends every request to the server at target, whatever
+// its original host.
+type redirectTransport struct {
+	target string
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, err := url.Parse(t.target)
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestSamePathPrefixOnlyCrawlsUnderStartDirectory(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/docs/v2/":
+			io.WriteString(w, `<a href="page.html">In scope</a><a href="/blog/">Out of scope</a>`)
+		case "/docs/v2/page.html":
+			io.WriteString(w, `<a href="/docs/v2/">Back</a>`)
+		case "/blog/":
+			io.WriteString(w, `<a href="/blog/post.html">Not followed</a>`)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.SamePathPrefix = true
+	c.Check(context.Background(), ts.URL+"/docs/v2/")
+	want := []string{
+		"GET /docs/v2/",
+		"GET /docs/v2/page.html",
+		"HEAD /blog/",
+	}
+	if !cmp.Equal(want, requests) {
+		t.Error(cmp.Diff(want, requests))
+	}
+	for _, res := range c.Results() {
+		if res.Status != weaver.StatusOK {
+			t.Errorf("want all links OK, got %v", res)
+		}
+	}
+}
+
+func TestResultStringIncludesMethodWhenNotGET(t *testing.T) {
+	t.Parallel()
+	res := weaver.Result{
+		Link:     "https://example.com/",
+		Status:   weaver.StatusOK,
+		Message:  "200 OK",
+		Referrer: "START",
+		Method:   http.MethodHead,
+	}
+	if got := res.String(); !strings.Contains(got, "] HEAD https://example.com/ (200 OK)") {
+		t.Errorf("want method in output, got %q", got)
+	}
+	res.Method = http.MethodGet
+	if got := res.String(); strings.Contains(got, "GET") {
+		t.Errorf("want no method in output for GET, got %q", got)
+	}
+}
+
+func TestRateLimitRetriesAreCapped(t *testing.T) {
+	t.Parallel()
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.MaxRateLimitRetries = 3
+	c.Check(context.Background(), ts.URL)
+	if requests != 4 {
+		t.Errorf("want 4 requests, got %d", requests)
+	}
+	want := []weaver.Result{{
+		Link:      ts.URL,
+		Status:    weaver.StatusWarning,
+		Message:   "429 Too Many Requests: gave up after rate-limit retries",
+		Referrer:  "START",
+		Referrers: []string{"START"},
+	}}
+	got := c.Results()
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestCheckOneChecksSingleLinkWithoutRecordingIt(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(testFS))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	want := weaver.Result{
+		Link:        ts.URL + "/bogus",
+		Status:      weaver.StatusError,
+		Message:     "404 Not Found",
+		Method:      http.MethodGet,
+		StatusCode:  http.StatusNotFound,
+		ContentType: "text/plain",
+	}
+	got := c.CheckOne(context.Background(), ts.URL+"/bogus")
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if len(c.Results()) > 0 || len(c.Visited()) > 0 {
+		t.Errorf("want nothing recorded, got results %v, visited %v", c.Results(), c.Visited())
+	}
+}
+
+func TestCrossDomainRedirectsAreReportedAsWarnings(t *testing.T) {
+	t.Parallel()
+	parked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer parked.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			io.WriteString(w, `<a href="/moved">Moved</a><a href="/away">Away</a>`)
+		case "/moved":
+			http.Redirect(w, r, "/", http.StatusMovedPermanently)
+		case "/away":
+			http.Redirect(w, r, parked.URL, http.StatusMovedPermanently)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.WarnCrossDomainRedirect = true
+	c.Check(context.Background(), ts.URL)
+	got := c.Results()
+	if len(got) != 3 {
+		t.Fatalf("unexpected result set %v", got)
+	}
+	if got[1].Status != weaver.StatusOK {
+		t.Errorf("want same-host redirect OK, got %v", got[1])
+	}
+	host, parkedHost := strings.TrimPrefix(ts.URL, "http://"), strings.TrimPrefix(parked.URL, "http://")
+	wantMsg := "200 OK, but redirects off-site from " + host + " to " + parkedHost
+	if got[2].Status != weaver.StatusWarning || got[2].Message != wantMsg {
+		t.Errorf("want warning %q, got %v", wantMsg, got[2])
+	}
+}
+
+func TestResolveOverridesConnectToGivenAddress(t *testing.T) {
+	t.Parallel()
+	var host string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.ResolveOverrides = map[string]string{"www.example.com": u.Hostname()}
+	site := "http://www.example.com:" + u.Port()
+	if err := c.Check(context.Background(), site); err != nil {
+		t.Fatal(err)
+	}
+	if host != "www.example.com:"+u.Port() {
+		t.Errorf("want original Host header, got %q", host)
+	}
+}
+
+func TestWarnPrivateTargetsFlagsLinksToPrivateAddresses(t *testing.T) {
+	t.Parallel()
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		io.WriteString(w, `<a href="http://localhost:8080/admin">Admin</a>
+<a href="http://10.1.2.3/">Intranet</a>
+<a href="http://[fe80::1]/">Link-local</a>`)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.WarnPrivateTargets = true
+	c.ResolveOverrides = map[string]string{"www.example.com": u.Hostname()}
+	c.Check(context.Background(), "http://www.example.com:"+u.Port())
+	if got := requests.Load(); got != 1 {
+		t.Errorf("want only the start page requested, got %d requests", got)
+	}
+	want := map[string]string{
+		"http://www.example.com:" + u.Port(): "200 OK",
+		"http://localhost:8080/admin":        "link to private address localhost on public site, not checked",
+		"http://10.1.2.3/":                   "link to private address 10.1.2.3 on public site, not checked",
+		"http://[fe80::1]/":                  "link to private address fe80::1 on public site, not checked",
+	}
+	got := map[string]string{}
+	for _, res := range c.Results() {
+		got[res.Link] = res.Message
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestQueryVariantsAreCappedPerPath(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			for i := range 5 {
+				fmt.Fprintf(w, `<a href="/list?page=%d">Page %d</a>`, i, i)
+			}
+			io.WriteString(w, `<a href="/other?page=1">Other</a>`)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.MaxQueryVariants = 3
+	c.Check(context.Background(), ts.URL)
+	want := map[string]weaver.Status{
+		ts.URL:                   weaver.StatusOK,
+		ts.URL + "/list?page=0":  weaver.StatusOK,
+		ts.URL + "/list?page=1":  weaver.StatusOK,
+		ts.URL + "/list?page=2":  weaver.StatusOK,
+		ts.URL + "/list?page=3":  weaver.StatusSkipped,
+		ts.URL + "/list?page=4":  weaver.StatusSkipped,
+		ts.URL + "/other?page=1": weaver.StatusOK,
+	}
+	got := map[string]weaver.Status{}
+	for _, res := range c.Results() {
+		got[res.Link] = res.Status
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestBodyMatchersSetStatusOfMatchingResponses(t *testing.T) {
+	t.Parallel()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/broken":
+			io.WriteString(w, `{"error": "not found"}`)
+		default:
+			io.WriteString(w, `{"data": "ok"}`)
+		}
+	}))
+	defer api.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintf(w, `<a href="%s/broken">Broken</a><a href="%s/ok">OK</a><a href="/error.html">Not JSON</a>`, api.URL, api.URL)
+		case "/error.html":
+			io.WriteString(w, `<p>{"error": "this is fine"}</p><a href="/">Links still followed</a>`)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.BodyMatchers = []weaver.BodyMatcher{{
+		ContentType: regexp.MustCompile(`^application/json`),
+		Body:        regexp.MustCompile(`"error":`),
+		Status:      weaver.StatusError,
+	}}
+	c.Check(context.Background(), ts.URL)
+	want := map[string]weaver.Status{
+		ts.URL:                 weaver.StatusOK,
+		api.URL + "/broken":    weaver.StatusError,
+		api.URL + "/ok":        weaver.StatusOK,
+		ts.URL + "/error.html": weaver.StatusOK,
+	}
+	got := map[string]weaver.Status{}
+	for _, res := range c.Results() {
+		got[res.Link] = res.Status
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if !cmp.Equal(c.Results()[0].Referrers, []string{"START", ts.URL + "/error.html"}) {
+		t.Errorf("want links on pages read by matchers still followed, got %v", c.Results()[0].Referrers)
+	}
+}
+
+func TestSortedResultsAreOrderedByStatusThenLink(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(testFS))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	var got []string
+	for _, res := range c.SortedResults() {
+		got = append(got, string(res.Status)+" "+res.Link)
+	}
+	want := []string{
+		"DEAD " + ts.URL + "/bogus",
+		"DEAD " + ts.URL + "/rust_rules.html",
+		"DEAD httq://invalid_scheme.html",
+		"WARN http:// /",
+		"OKAY " + ts.URL,
+		"OKAY " + ts.URL + "/go/post.html",
+		"OKAY " + ts.URL + "/go/sucks.html",
+		"OKAY " + ts.URL + "/invalid_links.html",
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestAllYieldsSameResultsAsResults(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(testFS))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	want := c.Results()
+	got := slices.Collect(c.All())
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	n := 0
+	for range c.All() {
+		n++
+		if n == 3 {
+			break
+		}
+	}
+	if n != 3 {
+		t.Errorf("want iteration to stop after 3 results, got %d", n)
+	}
+}
+
+func TestAllYieldsResultsRecordedDuringIteration(t *testing.T) {
+	t.Parallel()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.RecordResult("https://example.com/a", "START", weaver.ErrSkip, nil)
+	var got []string
+	for res := range c.All() {
+		got = append(got, res.Link)
+		if res.Link == "https://example.com/a" {
+			c.RecordResult("https://example.com/b", "START", weaver.ErrSkip, nil)
+		}
+	}
+	want := []string{"https://example.com/a", "https://example.com/b"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestErrorsOnlyPrintsErrorsButRecordsEverything(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			io.WriteString(w, `<a href="/missing">Missing</a><a href="/teapot">Teapot</a>`)
+		case "/missing":
+			http.NotFound(w, r)
+		case "/teapot":
+			w.WriteHeader(http.StatusTeapot)
+		}
+	}))
+	defer ts.Close()
+	output := new(bytes.Buffer)
+	c := weaver.NewChecker()
+	c.Output = output
+	c.Color = weaver.ColorNever
+	c.Limiter.SetLimit(rate.Inf)
+	c.ErrorsOnly = true
+	c.Check(context.Background(), ts.URL)
+	want := "[DEAD] " + ts.URL + "/missing (404 Not Found) — referrer: " + ts.URL + "\n"
+	got := output.String()
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if len(c.Results()) != 3 {
+		t.Errorf("want all results recorded, got %v", c.Results())
+	}
+}
+
+func TestSlowExternalLinksAreReportedAsWarnings(t *testing.T) {
+	t.Parallel()
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer external.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<a href="%s/slow">Slow</a><a href="%s/fast">Fast</a>`, external.URL, external.URL)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.MaxResponseTime = 50 * time.Millisecond
+	c.Check(context.Background(), ts.URL)
+	got := c.Results()
+	if len(got) != 3 {
+		t.Fatalf("unexpected result set %v", got)
+	}
+	if got[1].Status != weaver.StatusWarning || !strings.Contains(got[1].Message, "slow response: 0.1s") {
+		t.Errorf("want slow response warning, got %v", got[1])
+	}
+	if got[2].Status != weaver.StatusOK {
+		t.Errorf("want fast response OK, got %v", got[2])
+	}
+}
+
+func TestResponsesWithRetryStatusCodesAreRetried(t *testing.T) {
+	t.Parallel()
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusRequestTimeout)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.RetryStatusCodes = []int{http.StatusRequestTimeout}
+	c.RetryBackoff = time.Millisecond
+	c.Check(context.Background(), ts.URL)
+	if got := requests.Load(); got != 3 {
+		t.Errorf("want 3 requests, got %d", got)
+	}
+	got := c.Results()
+	if len(got) != 1 || got[0].Status != weaver.StatusOK {
+		t.Errorf("want OK after retries, got %v", got)
+	}
+}
+
+func TestRetriesStopAfterMaxRetries(t *testing.T) {
+	t.Parallel()
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.MaxRetries = 1
+	c.RetryBackoff = time.Millisecond
+	c.Check(context.Background(), ts.URL)
+	if got := requests.Load(); got != 2 {
+		t.Errorf("want 2 requests, got %d", got)
+	}
+	got := c.Results()
+	if len(got) != 1 || got[0].Message != "503 Service Unavailable" {
+		t.Errorf("want final response recorded, got %v", got)
+	}
+}
+
+func TestConnectionResetsAreRetried(t *testing.T) {
+	t.Parallel()
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) > 1 {
+			return
+		}
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// closing with no linger sends a TCP RST, rather than FIN
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.RetryBackoff = time.Millisecond
+	c.Check(context.Background(), ts.URL)
+	if got := requests.Load(); got != 2 {
+		t.Errorf("want 2 requests, got %d", got)
+	}
+	got := c.Results()
+	if len(got) != 1 || got[0].Status != weaver.StatusOK {
+		t.Errorf("want OK after retry, got %v", got)
+	}
+}
+
+func TestConnectionResetsAreNotRetriedIfRetryOnResetIsFalse(t *testing.T) {
+	t.Parallel()
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.RetryOnReset = false
+	c.Check(context.Background(), ts.URL)
+	if got := requests.Load(); got != 1 {
+		t.Errorf("want 1 request, got %d", got)
+	}
+}
+
+func TestCacheSendsConditionalRequestsOnLaterChecks(t *testing.T) {
+	t.Parallel()
+	var conditional atomic.Int32
+	fileServer := http.FileServerFS(testFS)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" {
+			conditional.Add(1)
+		}
+		w.Header().Set("ETag", strconv.Quote(r.URL.Path))
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	check := func() []weaver.Result {
+		t.Helper()
+		cache, err := weaver.OpenCache(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := weaver.NewChecker()
+		c.Output = io.Discard
+		c.Limiter.SetLimit(rate.Inf)
+		c.Cache = cache
+		c.Check(context.Background(), ts.URL)
+		if err := cache.Save(); err != nil {
+			t.Fatal(err)
+		}
+		return c.Results()
+	}
+	first := check()
+	if conditional.Load() != 0 {
+		t.Fatalf("want no conditional requests on first check, got %d", conditional.Load())
+	}
+	second := check()
+	if conditional.Load() == 0 {
+		t.Error("want conditional requests on second check, got none")
+	}
+	statuses := func(results []weaver.Result) map[string]weaver.Status {
+		m := map[string]weaver.Status{}
+		for _, res := range results {
+			m[res.Link] = res.Status
+		}
+		return m
+	}
+	if !cmp.Equal(statuses(first), statuses(second)) {
+		t.Error(cmp.Diff(statuses(first), statuses(second)))
+	}
+	for _, res := range first {
+		if res.FromCache {
+			t.Errorf("%s: want not from cache on first check", res.Link)
+		}
+	}
+	var notModified int
+	for _, res := range second {
+		if res.Message == "304 Not Modified" {
+			notModified++
+		}
+		if res.FromCache != (res.Message == "304 Not Modified") {
+			t.Errorf("%s: want FromCache only for unmodified links, got %t (%s)", res.Link, res.FromCache, res.Message)
+		}
+	}
+	if notModified == 0 {
+		t.Errorf("want some links not modified, got %v", second)
+	}
+}
+
+func TestPerURLBudgetLimitsTimeSpentRetrying(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.MaxRetries = 10
+	c.RetryBackoff = 100 * time.Millisecond
+	c.PerURLBudget = 150 * time.Millisecond
+	start := time.Now()
+	c.Check(context.Background(), ts.URL)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("want check to give up within budget, took %s", elapsed)
+	}
+	got := c.Results()
+	if len(got) != 1 || got[0].ErrorKind != weaver.ErrorKindTimeout {
+		t.Errorf("want timeout, got %v", got)
+	}
+}
+
+func TestSummaryTotalsResults(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(testFS))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	got := c.Summary()
+	if got.Total != 8 || got.OK != 4 || got.Errors != 3 || got.Warnings != 1 || got.Skipped != 0 {
+		t.Errorf("unexpected summary %+v", got)
+	}
+	if got.BytesDownloaded == 0 || got.Elapsed == 0 {
+		t.Errorf("want bytes and elapsed time recorded, got %+v", got)
+	}
+	if !strings.HasPrefix(got.String(), "Links: 8 (4 OK, 3 errors, 1 warnings) [") {
+		t.Errorf("unexpected summary line %q", got.String())
+	}
+}
+
+func TestSummaryBreaksDownFailuresByStatusCodeAndKind(t *testing.T) {
+	t.Parallel()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintf(w, `<a href="/a">A</a><a href="/b">B</a><a href="/c">C</a><a href="%s/">Gone</a>`, closed.URL)
+		case "/c":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	got := c.Summary()
+	wantCodes := map[int]int{http.StatusNotFound: 2, http.StatusInternalServerError: 1}
+	if !cmp.Equal(wantCodes, got.StatusCodeCounts) {
+		t.Error(cmp.Diff(wantCodes, got.StatusCodeCounts))
+	}
+	wantKinds := map[weaver.ErrorKind]int{weaver.ErrorKindRefused: 1}
+	if !cmp.Equal(wantKinds, got.ErrorKindCounts) {
+		t.Error(cmp.Diff(wantKinds, got.ErrorKindCounts))
+	}
+	wantBreakdown := "404: 2, 500: 1, refused: 1"
+	if got.Breakdown() != wantBreakdown {
+		t.Errorf("want breakdown %q, got %q", wantBreakdown, got.Breakdown())
+	}
+}
+
+func TestMaxTotalBytesStopsCrawlOnceReached(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		fmt.Fprintf(w, `<a href="/%d">Next</a>`, n+1)
+		io.WriteString(w, strings.Repeat("x", 1000))
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.MaxTotalBytes = 2500
+	c.Check(context.Background(), ts.URL)
+	got := c.Summary()
+	if got.Total != 3 {
+		t.Errorf("want 3 links checked before reaching the limit, got %d", got.Total)
+	}
+	if !got.ByteCapReached {
+		t.Error("want ByteCapReached set")
+	}
+}
+
+func TestMaxRedirectsLimitsRedirectChains(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hops/"))
+		if err != nil || n == 0 {
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/hops/%d", n-1), http.StatusFound)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.MaxRedirects = 2
+	res := c.CheckOne(context.Background(), ts.URL+"/hops/2")
+	if res.Status != weaver.StatusOK {
+		t.Errorf("want two redirects followed, got %v", res)
+	}
+	if res.Redirects != 2 {
+		t.Errorf("want 2 redirects, got %d", res.Redirects)
+	}
+	res = c.CheckOne(context.Background(), ts.URL+"/hops/3")
+	if res.Status != weaver.StatusError {
+		t.Errorf("want status %q, got %q", weaver.StatusError, res.Status)
+	}
+	if res.Message != "too many redirects" {
+		t.Errorf("want message %q, got %q", "too many redirects", res.Message)
+	}
+}
+
+func TestWaitForServerWaitsUntilServerResponds(t *testing.T) {
+	t.Parallel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	ts := httptest.NewUnstartedServer(nil)
+	defer ts.Close()
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+		ts.Listener = l
+		ts.Start()
+	}()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	err = c.WaitForServer(context.Background(), "http://"+addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForServerTimesOutIfServerNeverResponds(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(nil)
+	ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	err := c.WaitForServer(context.Background(), ts.URL, 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("want error when server never responds, got nil")
+	}
+	if !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("want timeout error, got %q", err)
+	}
+}
+
+func TestLinkSelectorsControlWhichLinksAreFollowed(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(fstest.MapFS{
+		"index.html": {Data: []byte(`<a href="/a.html">A</a>
+<div data-href="/b.html">B</div>`)},
+		"a.html": {Data: []byte(`A`)},
+		"b.html": {Data: []byte(`B`)},
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.LinkSelectors = []string{"//a/@href", "//div/@data-href"}
+	err := c.Check(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{ts.URL, ts.URL + "/a.html", ts.URL + "/b.html"}
+	var got []string
+	for _, res := range c.Results() {
+		got = append(got, res.Link)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestCrawlFollowsAreaAndGETFormLinks(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(fstest.MapFS{
+		"index.html": {Data: []byte(`<map name="m"><area href="/area.html"></map>
+<form action="/search"><input name="q"></form>
+<form method="POST" action="/login"></form>`)},
+		"area.html": {Data: []byte(`Area`)},
+		"search":    {Data: []byte(`Search`)},
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.LinkSelectors = append(c.LinkSelectors, weaver.FormLinkSelector)
+	c.Check(context.Background(), ts.URL)
+	want := map[string]string{
+		ts.URL:                "",
+		ts.URL + "/area.html": "area",
+		ts.URL + "/search":    "form",
+	}
+	got := map[string]string{}
+	for _, res := range c.Results() {
+		got[res.Link] = res.Kind
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestOpenGraphImageSelectorChecksSocialPreviewImagesWithHEAD(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(fstest.MapFS{
+		"index.html": {Data: []byte(`<head>
+<meta property="og:image" content="/preview.png">
+<meta name="twitter:image" content="/missing.png">
+<meta name="description" content="/not-a-link">
+</head>`)},
+		"preview.png": {Data: []byte("PNG")},
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.LinkSelectors = append(c.LinkSelectors, weaver.OpenGraphImageSelector)
+	c.Check(context.Background(), ts.URL)
+	want := map[string]string{
+		ts.URL:                  "OKAY  GET",
+		ts.URL + "/preview.png": "OKAY og:image HEAD",
+		ts.URL + "/missing.png": "DEAD og:image HEAD",
+	}
+	got := map[string]string{}
+	for _, res := range c.Results() {
+		got[res.Link] = fmt.Sprintf("%s %s %s", res.Status, res.Kind, res.Method)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestCheckReturnsErrorForInvalidLinkSelector(t *testing.T) {
+	t.Parallel()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.LinkSelectors = []string{"//a/@href", "//a[@href"}
+	err := c.Check(context.Background(), "http://example.invalid")
+	if err == nil {
+		t.Fatal("want error for invalid link selector, got nil")
+	}
+	if !strings.Contains(err.Error(), `invalid link selector "//a[@href"`) {
+		t.Errorf("unexpected error %q", err)
+	}
+}
+
+func TestCrawlToleratesSloppyHrefs(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(fstest.MapFS{
+		"index.html": {Data: []byte(`<a href="  /spaced.html  ">Spaced</a>
+<a href="\docs\backslash.html">Backslash</a>
+<a href="/wrapped
+.html">Wrapped</a>
+<a href="http://[::1">Malformed</a>`)},
+		"spaced.html":         {Data: []byte(`Spaced`)},
+		"docs/backslash.html": {Data: []byte(`Backslash`)},
+		"wrapped.html":        {Data: []byte(`Wrapped`)},
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Check(context.Background(), ts.URL)
+	want := map[string]weaver.Status{
+		ts.URL:                          weaver.StatusOK,
+		ts.URL + "/spaced.html":         weaver.StatusOK,
+		ts.URL + "/docs/backslash.html": weaver.StatusOK,
+		ts.URL + "/wrapped.html":        weaver.StatusOK,
+		"http://[::1":                   weaver.StatusWarning,
+	}
+	got := map[string]weaver.Status{}
+	for _, res := range c.Results() {
+		got[res.Link] = res.Status
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestPerHostConcurrencyLimitsSimultaneousRequests(t *testing.T) {
+	t.Parallel()
+	var inFlight, maxInFlight atomic.Int32
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.PerHostConcurrency = 1
+	c.HTTPClient.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Body:       io.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil
+	})
+	c.CheckOne(context.Background(), "http://example.com/")
+	var wg sync.WaitGroup
+	for i := range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.CheckOne(context.Background(), fmt.Sprintf("http://example.com/%d", i))
+		}()
+	}
+	wg.Wait()
+	if got := maxInFlight.Load(); got != 1 {
+		t.Errorf("want at most 1 request in flight, got %d", got)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestLoginSubmitsFormAndKeepsSessionCookie(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			if r.PostFormValue("email") != "me@example.com" || r.PostFormValue("pass") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "ok", Path: "/"})
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		default:
+			if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "ok" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			io.WriteString(w, `<a href="/private">Private</a>`)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Login = &weaver.Login{
+		URL:       ts.URL + "/login",
+		UserField: "email",
+		PassField: "pass",
+		User:      "me@example.com",
+		Password:  "secret",
+	}
+	err := c.Check(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range c.Results() {
+		if res.Status != weaver.StatusOK {
+			t.Errorf("want all pages OK after login, got %v", res)
+		}
+	}
+	c = weaver.NewChecker()
+	c.Output = io.Discard
+	c.Login = &weaver.Login{
+		URL:       ts.URL + "/login",
+		UserField: "email",
+		PassField: "pass",
+		User:      "me@example.com",
+		Password:  "wrong",
+	}
+	err = c.Check(context.Background(), ts.URL)
+	if err == nil {
+		t.Error("want error for failed login, got nil")
+	}
+}
+
+func TestIgnoreResultsSuppressesKnownFailures(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, `<a href="/known-broken">Known</a><a href="/broken">Broken</a>`)
+	}))
+	defer ts.Close()
+	output := new(bytes.Buffer)
+	c := weaver.NewChecker()
+	c.Output = output
+	c.Verbose = true
+	c.Limiter.SetLimit(rate.Inf)
+	c.IgnoreResults = []*regexp.Regexp{regexp.MustCompile(`/known-`)}
+	c.Check(context.Background(), ts.URL)
+	if strings.Contains(output.String(), "known-broken") {
+		t.Errorf("ignored link printed: %q", output.String())
+	}
+	if got := c.Summary().Errors; got != 1 {
+		t.Errorf("want 1 error, got %d", got)
+	}
+	var ignored []weaver.Result
+	for _, res := range c.Results() {
+		if res.Ignored {
+			ignored = append(ignored, res)
+		}
+	}
+	if len(ignored) != 1 {
+		t.Fatalf("want 1 ignored result, got %v", ignored)
+	}
+	res := ignored[0]
+	if res.Link != ts.URL+"/known-broken" || res.Status != weaver.StatusSkipped || res.Message != "ignored: 404 Not Found" {
+		t.Errorf("unexpected ignored result %v", res)
+	}
+}
+
+func TestProtocolRelativeLinksResolveAgainstPageScheme(t *testing.T) {
+	t.Parallel()
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer cdn.Close()
+	host := strings.TrimPrefix(cdn.URL, "http://")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<a href="//`+host+`/x.js">CDN</a>`)
+	}))
+	defer ts.Close()
+	tcs := map[bool]weaver.Status{
+		false: weaver.StatusOK,
+		true:  weaver.StatusWarning,
+	}
+	for warn, want := range tcs {
+		c := weaver.NewChecker()
+		c.Output = io.Discard
+		c.Limiter.SetLimit(rate.Inf)
+		c.WarnProtocolRelative = warn
+		c.Check(context.Background(), ts.URL)
+		got := c.Results()
+		if len(got) != 2 {
+			t.Fatalf("want 2 results, got %v", got)
+		}
+		res := got[1]
+		if res.Link != cdn.URL+"/x.js" {
+			t.Errorf("want link resolved to %q, got %q", cdn.URL+"/x.js", res.Link)
+		}
+		if res.Status != want {
+			t.Errorf("WarnProtocolRelative %t: want status %q, got %q", warn, want, res.Status)
+		}
+		if warn && !strings.Contains(res.Message, "protocol-relative link resolves to "+cdn.URL+"/x.js") {
+			t.Errorf("unexpected message %q", res.Message)
+		}
+	}
+}
+
+func TestHeadFirstSavesDownloadingUnparsedPages(t *testing.T) {
+	t.Parallel()
+	big := strings.Repeat("x", 100_000)
+	var served atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		switch r.URL.Path {
+		case "/":
+			n, _ = io.WriteString(w, `<a href="/big.pdf">PDF</a><a href="/noHEAD.pdf">No HEAD</a>`)
+		case "/noHEAD.pdf":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("Content-Type", "application/pdf")
+			n, _ = io.WriteString(w, "small")
+		default:
+			w.Header().Set("Content-Type", "application/pdf")
+			n, _ = io.WriteString(w, big)
+		}
+		if r.Method != http.MethodHead {
+			served.Add(int64(n))
+		}
+	}))
+	defer ts.Close()
+	bytesServed := map[bool]int64{}
+	for _, headFirst := range []bool{false, true} {
+		served.Store(0)
+		c := weaver.NewChecker()
+		c.Output = io.Discard
+		c.Limiter.SetLimit(rate.Inf)
+		c.HeadFirst = headFirst
+		c.Check(context.Background(), ts.URL)
+		for _, res := range c.Results() {
+			if res.Status != weaver.StatusOK {
+				t.Errorf("HeadFirst %t: want all links OK, got %v", headFirst, res)
+			}
+		}
+		bytesServed[headFirst] = served.Load()
+	}
+	// the PDF is downloaded only without HeadFirst
+	if bytesServed[false] < int64(len(big)) {
+		t.Errorf("want at least %d bytes served without HeadFirst, got %d", len(big), bytesServed[false])
+	}
+	if bytesServed[true] >= int64(len(big)) {
+		t.Errorf("want less than %d bytes served with HeadFirst, got %d", len(big), bytesServed[true])
+	}
+}
+
+func TestStrategyBFSRecordsShortestPathReferrer(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"index.html": {Data: []byte(`<a href="deep1.html">Deep</a> <a href="gone.html">Gone</a>`)},
+		"deep1.html": {Data: []byte(`<a href="deep2.html">Deeper</a>`)},
+		"deep2.html": {Data: []byte(`<a href="gone.html">Gone</a>`)},
+	}
+	ts := httptest.NewServer(http.FileServerFS(fsys))
+	defer ts.Close()
+	tcs := []struct {
+		strategy     weaver.Strategy
+		wantReferrer string
+		wantDepth    int
+	}{
+		{strategy: weaver.StrategyDFS, wantReferrer: ts.URL + "/deep2.html", wantDepth: 3},
+		{strategy: weaver.StrategyBFS, wantReferrer: ts.URL, wantDepth: 1},
+	}
+	for _, tc := range tcs {
+		c := weaver.NewChecker()
+		c.Output = io.Discard
+		c.Limiter.SetLimit(rate.Inf)
+		c.Strategy = tc.strategy
+		c.Check(context.Background(), ts.URL)
+		var gone weaver.Result
+		for _, res := range c.Results() {
+			if res.Link == ts.URL+"/gone.html" {
+				gone = res
+			}
+		}
+		if gone.Referrer != tc.wantReferrer || gone.Depth != tc.wantDepth {
+			t.Errorf("%s: want referrer %q at depth %d, got %q at depth %d", tc.strategy, tc.wantReferrer, tc.wantDepth, gone.Referrer, gone.Depth)
+		}
+	}
+}
+
+func TestCheckDoesNotFollowLinksOnNoFollowPages(t *testing.T) {
+	t.Parallel()
+	fileServer := http.FileServerFS(fstest.MapFS{
+		"index.html":    {Data: []byte(`<a href="nofollow.html">No follow</a> <a href="otherbot.html">Other bot</a>`)},
+		"nofollow.html": {Data: []byte(`<a href="hidden.html">Hidden</a>`)},
+		"otherbot.html": {Data: []byte(`<a href="followed.html">Followed</a>`)},
+		"hidden.html":   {},
+		"followed.html": {},
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/nofollow.html":
+			w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+		case "/otherbot.html":
+			w.Header().Set("X-Robots-Tag", "otherbot: nofollow")
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	tcs := []struct {
+		ignore bool
+		want   []string
+	}{
+		{
+			ignore: false,
+			want:   []string{ts.URL, ts.URL + "/nofollow.html", ts.URL + "/otherbot.html", ts.URL + "/followed.html"},
+		},
+		{
+			ignore: true,
+			want:   []string{ts.URL, ts.URL + "/nofollow.html", ts.URL + "/hidden.html", ts.URL + "/otherbot.html", ts.URL + "/followed.html"},
+		},
+	}
+	for _, tc := range tcs {
+		c := weaver.NewChecker()
+		c.Output = io.Discard
+		c.Limiter.SetLimit(rate.Inf)
+		c.IgnoreRobotsTag = tc.ignore
+		c.Check(context.Background(), ts.URL)
+		var got []string
+		for _, res := range c.Results() {
+			got = append(got, res.Link)
+		}
+		if !cmp.Equal(tc.want, got) {
+			t.Errorf("IgnoreRobotsTag %t: %s", tc.ignore, cmp.Diff(tc.want, got))
+		}
+	}
+}
+
+func TestStrictModeReportsWarningsAsErrors(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.FileServerFS(testFS))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.Strict = true
+	c.Check(context.Background(), ts.URL)
+	summary := c.Summary()
+	if summary.Errors != 4 || summary.Warnings != 0 {
+		t.Errorf("want 4 errors and no warnings, got %d errors and %d warnings", summary.Errors, summary.Warnings)
+	}
+}
+
+func TestSchemeHandlersCheckLinksWithOtherSchemes(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<a href="ftp://files.example.com/ok.txt">OK</a>
+<a href="ftp://files.example.com/missing.txt">Missing</a>
+<a href="gopher://example.com/">Unsupported</a>`)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.SchemeHandlers["ftp"] = func(ctx context.Context, u *url.URL) weaver.Result {
+		if u.Path == "/ok.txt" {
+			return weaver.Result{Status: weaver.StatusOK, Message: "file exists"}
+		}
+		return weaver.Result{Status: weaver.StatusError, Message: "file not found"}
+	}
+	c.Check(context.Background(), ts.URL)
+	want := map[string]string{
+		ts.URL:                                "OKAY 200 OK",
+		"ftp://files.example.com/ok.txt":      "OKAY file exists",
+		"ftp://files.example.com/missing.txt": "DEAD file not found",
+		"gopher://example.com/":               `DEAD Get "gopher://example.com/": unsupported protocol scheme "gopher"`,
+	}
+	got := map[string]string{}
+	for _, res := range c.Results() {
+		got[res.Link] = string(res.Status) + " " + res.Message
+		if res.Link != ts.URL && res.Referrer != ts.URL {
+			t.Errorf("%s: want referrer %q, got %q", res.Link, ts.URL, res.Referrer)
+		}
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	res := c.CheckOne(context.Background(), "ftp://files.example.com/ok.txt")
+	if res.Status != weaver.StatusOK || res.Link != "ftp://files.example.com/ok.txt" {
+		t.Errorf("want CheckOne to use scheme handler, got %v", res)
+	}
+}
+
+func TestSlowBodyIsRecordedAsTimeout(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, "<html><body>")
+		http.NewResponseController(w).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.BodyTimeout = 50 * time.Millisecond
+	start := time.Now()
+	c.Check(context.Background(), ts.URL)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("want check to stop reading slow body, took %s", elapsed)
+	}
+	got := c.Results()
+	if len(got) != 1 || got[0].Status != weaver.StatusError || got[0].ErrorKind != weaver.ErrorKindTimeout {
+		t.Errorf("want timeout error, got %v", got)
+	}
+}
+
+func TestExternalPolicyAppliesOnlyToExternalLinks(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var externalMethods []string
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		externalMethods = append(externalMethods, r.Method)
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer external.Close()
+	var internalRequests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			io.WriteString(w, `<a href="/flaky">Flaky</a> <a href="`+external.URL+`/">External</a>`)
+		case "/flaky":
+			internalRequests.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.RetryBackoff = time.Millisecond
+	c.ExternalPolicy = &weaver.ExternalPolicy{MaxRetries: 0, Method: http.MethodHead}
+	c.Check(context.Background(), ts.URL)
+	if got := internalRequests.Load(); got != 3 {
+		t.Errorf("want internal link tried 3 times, got %d", got)
+	}
+	want := []string{http.MethodHead}
+	if !cmp.Equal(want, externalMethods) {
+		t.Error(cmp.Diff(want, externalMethods))
+	}
+}
+
+func TestExternalPolicyTimesOutSlowExternalLinks(t *testing.T) {
+	t.Parallel()
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer external.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<a href="`+external.URL+`/">External</a>`)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Inf)
+	c.ExternalPolicy = &weaver.ExternalPolicy{Timeout: 50 * time.Millisecond}
+	c.Check(context.Background(), ts.URL)
+	got := c.Results()
+	if len(got) != 2 || got[1].ErrorKind != weaver.ErrorKindTimeout {
+		t.Errorf("want external link timed out, got %v", got)
+	}
+}
+
 func TestReduceLimit_SetsCorrectLimit(t *testing.T) {
 	t.Parallel()
 	a := weaver.NewAdaptiveRateLimiter()
@@ -93,6 +2753,190 @@ func TestReduceLimit_SetsCorrectLimit(t *testing.T) {
 	}
 }
 
+func TestReduceLimit_DoesNotGoBelowMinRate(t *testing.T) {
+	t.Parallel()
+	a := weaver.NewAdaptiveRateLimiter()
+	a.MinRate = 0.5
+	for range 100 {
+		a.ReduceLimit()
+	}
+	want := rate.Limit(0.5)
+	got := a.Limit()
+	if want != got {
+		t.Errorf("want %.2f, got %.2f", want, got)
+	}
+	if !a.ReduceLimit() {
+		t.Error("want ReduceLimit to report rate at floor")
+	}
+}
+
+func TestGraduallyIncreaseRateLimit_UsesConfiguredGrowthAndMaximum(t *testing.T) {
+	t.Parallel()
+	a := weaver.NewAdaptiveRateLimiter()
+	a.MaxRate = 10
+	a.GrowthFactor = 2
+	a.CooldownPeriod = -1
+	a.SetLimit(4)
+	for _, want := range []rate.Limit{8, 10} {
+		if !a.GraduallyIncreaseRateLimit() {
+			t.Fatal("want rate limit increased")
+		}
+		got := a.Limit()
+		if want != got {
+			t.Errorf("want %.2f, got %.2f", want, got)
+		}
+	}
+	if a.GraduallyIncreaseRateLimit() {
+		t.Errorf("want no increase beyond MaxRate, got %.2f", a.Limit())
+	}
+}
+
+func TestSetMaxRate_AppliesToCurrentRate(t *testing.T) {
+	t.Parallel()
+	a := weaver.NewAdaptiveRateLimiter()
+	a.SetMaxRate(2)
+	if got := a.Limit(); got != 2 {
+		t.Errorf("want rate lowered to 2.00, got %.2f", got)
+	}
+	a.SetMaxRate(20)
+	if got := a.Limit(); got != 20 {
+		t.Errorf("want rate raised to 20.00, got %.2f", got)
+	}
+	a.ReduceLimit()
+	a.SetMaxRate(40)
+	if got := a.Limit(); got != 10 {
+		t.Errorf("want reduced rate of 10.00 kept, got %.2f", got)
+	}
+}
+
+func TestLoweredMaxRateSpacesRequests(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var times []time.Time
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		if r.URL.Path == "/" {
+			io.WriteString(w, `<html><body><a href="/a">A</a><a href="/b">B</a></body></html>`)
+		}
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetMaxRate(4)
+	c.Check(context.Background(), ts.URL)
+	if len(times) != 3 {
+		t.Fatalf("want 3 requests, got %d", len(times))
+	}
+	want := time.Second / 4
+	for i := 1; i < len(times); i++ {
+		// allow for variation in the time taken for requests to arrive
+		if gap := times[i].Sub(times[i-1]); gap < want*9/10 {
+			t.Errorf("want at least %s between requests, got %s", want, gap)
+		}
+	}
+}
+
+func TestGraduallyIncreaseRateLimit_WaitsForCooldownPeriod(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	a := weaver.NewAdaptiveRateLimiter()
+	a.Now = func() time.Time { return now }
+	a.MaxRate = 100
+	a.GrowthFactor = 2
+	a.CooldownPeriod = 10 * time.Second
+	a.SetLimit(4)
+	a.ReduceLimit()
+	now = now.Add(5 * time.Second)
+	if a.GraduallyIncreaseRateLimit() {
+		t.Fatalf("want no increase during cooldown, got %.2f", a.Limit())
+	}
+	now = now.Add(6 * time.Second)
+	if !a.GraduallyIncreaseRateLimit() {
+		t.Fatal("want increase after cooldown")
+	}
+	if got := a.Limit(); got != 4 {
+		t.Errorf("want 4.00, got %.2f", got)
+	}
+	if a.GraduallyIncreaseRateLimit() {
+		t.Fatalf("want cooldown restarted after increase, got %.2f", a.Limit())
+	}
+	now = now.Add(11 * time.Second)
+	if !a.GraduallyIncreaseRateLimit() {
+		t.Fatal("want increase after second cooldown")
+	}
+	if got := a.Limit(); got != 8 {
+		t.Errorf("want 8.00, got %.2f", got)
+	}
+}
+
+func TestAdaptiveRateLimiter_WaitReturnsErrorWhenCancelled(t *testing.T) {
+	t.Parallel()
+	a := weaver.NewAdaptiveRateLimiter()
+	a.SetLimit(rate.Every(time.Hour))
+	if err := a.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := a.Wait(ctx)
+	if err == nil {
+		t.Fatal("want error when cancelled while waiting, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("want Wait to return promptly on cancellation, took %s", elapsed)
+	}
+}
+
+func TestCrawlSendsNoRequestWhenCancelledWhileThrottled(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var requested []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		io.WriteString(w, `<a href="/next">Next</a>`)
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.Limiter.SetLimit(rate.Every(10 * time.Second))
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	c.Check(ctx, ts.URL)
+	mu.Lock()
+	defer mu.Unlock()
+	if !cmp.Equal([]string{"/"}, requested) {
+		t.Errorf("want only the start page requested, got %v", requested)
+	}
+	for _, res := range c.Results() {
+		if res.Link == ts.URL+"/next" {
+			t.Errorf("want no result for link cancelled while waiting, got %+v", res)
+		}
+	}
+}
+
+func TestAdaptiveRateLimiter_IsSafeForConcurrentUse(t *testing.T) {
+	t.Parallel()
+	a := weaver.NewAdaptiveRateLimiter()
+	a.SetLimit(rate.Inf)
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.Wait(context.Background())
+			a.ReduceLimit()
+			a.GraduallyIncreaseRateLimit()
+			a.SetLimit(a.Limit())
+		}()
+	}
+	wg.Wait()
+}
+
 func TestCertVerifyFailuresAreRecordedAsWarnings(t *testing.T) {
 	t.Parallel()
 	ts := httptest.NewTLSServer(nil)
@@ -112,6 +2956,82 @@ func TestCertVerifyFailuresAreRecordedAsWarnings(t *testing.T) {
 	if res.Status != weaver.StatusWarning {
 		t.Errorf("want status %q, got %q", weaver.StatusWarning, res.Status)
 	}
+	if res.ErrorKind != weaver.ErrorKindTLS {
+		t.Errorf("want error kind %q, got %q", weaver.ErrorKindTLS, res.ErrorKind)
+	}
+}
+
+func TestCheckOneClassifiesRefusedConnections(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(nil)
+	ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	res := c.CheckOne(context.Background(), ts.URL)
+	if res.ErrorKind != weaver.ErrorKindRefused {
+		t.Errorf("want error kind %q, got %q (%s)", weaver.ErrorKindRefused, res.ErrorKind, res.Message)
+	}
+	if res.Message != "connection refused" {
+		t.Errorf("want message %q, got %q", "connection refused", res.Message)
+	}
+}
+
+func TestCheckOneClassifiesTimeouts(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.HTTPClient.Timeout = 50 * time.Millisecond
+	res := c.CheckOne(context.Background(), ts.URL)
+	if res.Status != weaver.StatusError {
+		t.Errorf("want status %q, got %q", weaver.StatusError, res.Status)
+	}
+	if res.ErrorKind != weaver.ErrorKindTimeout {
+		t.Errorf("want error kind %q, got %q (%s)", weaver.ErrorKindTimeout, res.ErrorKind, res.Message)
+	}
+}
+
+func TestInsecureSkipVerifyAcceptsUntrustedCertificates(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.InsecureSkipVerify = true
+	c.Check(context.Background(), ts.URL)
+	got := c.Results()
+	if len(got) != 1 {
+		t.Fatalf("unexpected result set %v", got)
+	}
+	if got[0].Status == weaver.StatusWarning {
+		t.Errorf("want certificate accepted, got %v", got[0])
+	}
+}
+
+func TestTransportOptionsLeaveSuppliedClientUnchanged(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+	transport := &http.Transport{}
+	client := &http.Client{Transport: transport}
+	c := weaver.NewChecker()
+	c.Output = io.Discard
+	c.HTTPClient = client
+	c.InsecureSkipVerify = true
+	c.Check(context.Background(), ts.URL)
+	got := c.Results()
+	if len(got) != 1 || got[0].Status == weaver.StatusWarning {
+		t.Errorf("want certificate accepted, got %v", got)
+	}
+	if client.Transport != transport || client.CheckRedirect != nil {
+		t.Error("want client unchanged")
+	}
+	if transport.DisableCompression || transport.TLSClientConfig != nil && transport.TLSClientConfig.InsecureSkipVerify {
+		t.Error("want transport unchanged")
+	}
 }
 
 var testFS = fstest.MapFS{